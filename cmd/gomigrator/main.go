@@ -3,8 +3,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	cfg "migrator/internal/config"
@@ -15,37 +21,123 @@ import (
 )
 
 var (
-	cfgFile string
+	cfgFile      string
+	outputFormat string
 )
 
 func main() {
 	root := &cobra.Command{
 		Use:   "gomigrator",
-		Short: "Database migration tool for PostgreSQL (SQL & Go)",
+		Short: "Database migration tool for PostgreSQL, MySQL and SQLite (SQL & Go)",
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			return checkOutput(cmd, outputFormat)
+		},
 	}
 
 	flags := root.PersistentFlags()
 	addCommonFlags(flags)
 	root.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "Path to config YAML")
+	root.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format: table|json|yaml")
 
-	root.AddCommand(cmdCreate(flags), cmdUp(flags), cmdDown(flags), cmdRedo(flags), cmdStatus(flags), cmdDBVersion(flags))
+	root.AddCommand(cmdCreate(flags), cmdUp(flags), cmdDown(flags), cmdRedo(flags), cmdStatus(flags), cmdDBVersion(flags), cmdVerify(flags),
+		cmdHistory(flags), cmdCheckSchema(flags), cmdDiff(flags), cmdValidate(flags), cmdLint(flags), cmdRepair(flags), cmdForce(flags), cmdMarkApplied(flags), cmdMarkRolledBack(flags))
 
-	if err := root.Execute(); err != nil {
+	ctx, stop := signalContext(context.Background(), os.Stderr)
+	err := root.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitInterrupted — код выхода при остановке по сигналу (128 + SIGINT).
+const exitInterrupted = 130
+
+func exitCode(err error) int {
+	if errors.Is(err, pub.ErrInterrupted) || errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	return 1
+}
+
+// signalContext возвращает контекст с двухступенчатой остановкой: первый SIGINT/SIGTERM
+// просит завершить текущую миграцию и не начинать следующую, второй отменяет и её
+// (транзакция откатывается).
+func signalContext(parent context.Context, w io.Writer) (context.Context, func()) {
+	ctx, abort := context.WithCancelCause(parent)
+	graceful, stopGraceful := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			_, _ = fmt.Fprintf(w, "received %s, stopping after the current migration (repeat to abort it)\n", sig)
+			stopGraceful(signalCause(sig, false))
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigs:
+			abort(signalCause(sig, true))
+		case <-done:
+		}
+	}()
+	return pub.WithStop(ctx, graceful), func() {
+		signal.Stop(sigs)
+		close(done)
+		abort(nil)
+		stopGraceful(nil)
+	}
+}
+
+// signalCause — причина остановки по сигналу. Она оборачивает ErrInterrupted,
+// чтобы код выхода был 130, где бы ни прервался запуск: при подключении,
+// ожидании блокировки, запросах журнала или во время миграции.
+func signalCause(sig os.Signal, again bool) error {
+	if again {
+		return fmt.Errorf("received %s again: %w", sig, pub.ErrInterrupted)
 	}
+	return fmt.Errorf("received %s: %w", sig, pub.ErrInterrupted)
 }
 
 func addCommonFlags(fs *pflag.FlagSet) {
-	fs.String("dsn", "", "PostgreSQL DSN")
+	fs.String("dsn", "", "Database DSN (postgres://, mysql:// or sqlite://)")
 	fs.String("path", "./migrations", "Path to migrations directory")
 	fs.String("kind", "sql", "Migration kind: sql|go")
 	fs.Int64("lock_key", 7243392, "Advisory lock key")
 	fs.String("schema_table", "schema_migrations", "Schema table name")
+	fs.Bool("allow_out_of_order", false, "Apply pending migrations older than the current database version")
+	fs.Duration("lock_wait_timeout", 0, "Maximum time to wait for the migration lock (0 waits forever)")
+	fs.Duration("statement_timeout", 0, "Default statement_timeout for each migration (0 keeps the database setting)")
+	fs.Duration("lock_timeout", 0, "Default lock_timeout for each migration (0 keeps the database setting)")
+	fs.Int("retry_attempts", 0, "Attempts per migration on lock timeouts, deadlocks and serialization failures (0 disables retries)")
+	fs.Duration("retry_backoff", time.Second, "Delay before the first retry, doubled for each next one")
+	fs.Duration("retry_max_backoff", 30*time.Second, "Maximum delay between retries")
+	fs.Float64("retry_jitter", 0.2, "Random spread of the retry delay as a fraction of it")
+	fs.String("schema_file", "", "Write a schema snapshot to this file after up, down and redo")
 }
 
 func loadConfig(flags *pflag.FlagSet) (cfg.Config, error) {
-	return cfg.Load(flags, cfgFile)
+	c, err := cfg.Load(flags, cfgFile)
+	if err != nil {
+		return c, err
+	}
+	c.OnLockWait = func(holders []pub.LockHolder) { printLockWait(os.Stderr, holders) }
+	return c, nil
+}
+
+// printLockWait сообщает, кто удерживает блокировку, пока команда ждёт её освобождения.
+func printLockWait(w io.Writer, holders []pub.LockHolder) {
+	if len(holders) == 0 {
+		_, _ = fmt.Fprintln(w, "waiting for the migration lock held by another process")
+		return
+	}
+	_, _ = fmt.Fprintln(w, "waiting for the migration lock held by:")
+	for _, h := range holders {
+		_, _ = fmt.Fprintf(w, "  %s\n", h)
+	}
 }
 
 func cmdCreate(flags *pflag.FlagSet) *cobra.Command {
@@ -75,32 +167,95 @@ func cmdCreate(flags *pflag.FlagSet) *cobra.Command {
 }
 
 func cmdUp(flags *pflag.FlagSet) *cobra.Command {
-	return &cobra.Command{Use: "up", Short: "Apply all pending migrations", RunE: func(_ *cobra.Command, _ []string) error {
+	var (
+		to     int64
+		dryRun bool
+	)
+	cmd := &cobra.Command{Use: "up", Short: "Apply all pending migrations", RunE: func(cmd *cobra.Command, _ []string) error {
 		c, err := loadConfig(flags)
 		if err != nil {
 			return err
 		}
-		return pub.RunUp(context.Background(), c)
+		if dryRun {
+			target := pub.NoTarget
+			if cmd.Flags().Changed("to") {
+				target = to
+			}
+			plan, err := pub.PlanUp(cmd.Context(), c, target)
+			if err != nil {
+				return err
+			}
+			return writePlan(cmd.OutOrStdout(), outputFormat, plan)
+		}
+		if cmd.Flags().Changed("to") {
+			return pub.RunUpTo(cmd.Context(), c, to)
+		}
+		return pub.RunUp(cmd.Context(), c)
 	}}
+	cmd.Flags().Int64Var(&to, "to", 0, "Apply migrations up to and including this version")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the migration plan without executing it")
+	return cmd
 }
 
 func cmdDown(flags *pflag.FlagSet) *cobra.Command {
-	return &cobra.Command{Use: "down", Short: "Rollback the last migration", RunE: func(_ *cobra.Command, _ []string) error {
+	var (
+		to     int64
+		steps  int
+		dryRun bool
+	)
+	cmd := &cobra.Command{Use: "down", Short: "Rollback the last migration", RunE: func(cmd *cobra.Command, _ []string) error {
 		c, err := loadConfig(flags)
 		if err != nil {
 			return err
 		}
-		return pub.RunDown(context.Background(), c)
+		if dryRun {
+			target, n := int64(-1), steps
+			if cmd.Flags().Changed("to") {
+				target, n = to, 0
+			}
+			plan, err := pub.PlanDown(cmd.Context(), c, target, n)
+			if err != nil {
+				return err
+			}
+			return writePlan(cmd.OutOrStdout(), outputFormat, plan)
+		}
+		switch {
+		case cmd.Flags().Changed("to"):
+			return pub.RunDownTo(cmd.Context(), c, to)
+		case cmd.Flags().Changed("steps"):
+			return pub.RunDownSteps(cmd.Context(), c, steps)
+		}
+		return pub.RunDown(cmd.Context(), c)
 	}}
+	cmd.Flags().Int64Var(&to, "to", 0, "Rollback all migrations newer than this version (0 rolls back everything)")
+	cmd.Flags().IntVar(&steps, "steps", 1, "Number of migrations to rollback")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the rollback plan without executing it")
+	cmd.MarkFlagsMutuallyExclusive("to", "steps")
+	return cmd
+}
+
+// printPlan выводит план миграций вместе с полным текстом SQL.
+func printPlan(w io.Writer, plan pub.Plan) {
+	if len(plan.Steps) == 0 {
+		_, _ = fmt.Fprintln(w, "Nothing to do")
+		return
+	}
+	for _, s := range plan.Steps {
+		mode := string(s.Kind)
+		if s.NoTransaction {
+			mode += ", no transaction"
+		}
+		_, _ = fmt.Fprintf(w, "-- %s %d_%s (%s)\n%s\n\n", s.Direction, s.Version, s.Name, mode, s.SQL)
+	}
 }
 
 func cmdRedo(flags *pflag.FlagSet) *cobra.Command {
-	return &cobra.Command{Use: "redo", Short: "Redo the last migration (down+up)", RunE: func(_ *cobra.Command, _ []string) error {
+	return &cobra.Command{Use: "redo", Short: "Redo the last migration (down+up)", RunE: func(cmd *cobra.Command, _ []string) error {
 		c, err := loadConfig(flags)
 		if err != nil {
 			return err
 		}
-		return pub.RunRedo(context.Background(), c)
+		return pub.RunRedo(cmd.Context(), c)
 	}}
 }
 
@@ -110,17 +265,253 @@ func cmdStatus(flags *pflag.FlagSet) *cobra.Command {
 		if err != nil {
 			return err
 		}
-		rows, err := pub.Status(context.Background(), c)
+		rows, err := pub.Status(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		return writeStatus(cmd.OutOrStdout(), outputFormat, rows)
+	}}
+}
+
+// printStatus выводит таблицу статуса миграций, разделённую табуляцией.
+func printStatus(w io.Writer, rows []pub.StatusRow) {
+	_, _ = fmt.Fprintln(w, "STATUS\tVERSION\tNAME\tAPPLIED_AT\tEXECUTION_MS\tCHECKSUM\tERROR")
+	for _, r := range rows {
+		status := r.Status
+		if r.OutOfOrder {
+			status += " (out-of-order)"
+		}
+		appliedAt := "-"
+		if r.AppliedAt != nil {
+			appliedAt = r.AppliedAt.Format(time.RFC3339)
+		}
+		errText := strings.ReplaceAll(r.ErrorText, "\n", " ")
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", status, r.Version, r.Name, appliedAt, r.ExecutionMs, shortChecksum(r.Checksum), errText)
+	}
+}
+
+// shortChecksum сокращает контрольную сумму для табличного вывода.
+func shortChecksum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+func cmdCheckSchema(flags *pflag.FlagSet) *cobra.Command {
+	var write bool
+	cmd := &cobra.Command{Use: "check-schema", Short: "Compare the schema file with the live database schema", RunE: func(cmd *cobra.Command, _ []string) error {
+		c, err := loadConfig(flags)
 		if err != nil {
 			return err
 		}
+		if c.SchemaFile == "" {
+			return errors.New("schema_file is not set")
+		}
 		w := cmd.OutOrStdout()
-		_, _ = fmt.Fprintln(w, "STATUS\tUPDATED_AT\tVERSION\tNAME")
-		for _, r := range rows {
-			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Status, r.UpdatedAt.Format(time.RFC3339), r.Version, r.Name)
+		if write {
+			snap, err := pub.SchemaSnapshot(cmd.Context(), c)
+			if err != nil {
+				return err
+			}
+			if err := pub.WriteSchemaFile(c.SchemaFile, snap); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(w, "Wrote %s\n", c.SchemaFile)
+			return nil
+		}
+		drift, err := pub.CheckSchema(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		if printSchemaDrift(w, drift, "the schema file") {
+			return fmt.Errorf("database schema differs from %s", c.SchemaFile)
 		}
 		return nil
 	}}
+	cmd.Flags().BoolVar(&write, "write", false, "Overwrite the schema file with the current database schema")
+	return cmd
+}
+
+func cmdValidate(flags *pflag.FlagSet) *cobra.Command {
+	var requireDown bool
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check migration files for mistakes without connecting to the database",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			// validate запускается в CI, где DSN обычно нет
+			c, err := cfg.LoadOffline(flags, cfgFile)
+			if err != nil {
+				return err
+			}
+			// флаг команды перекрывает require_down из файла и окружения, только если задан явно
+			if cmd.Flags().Changed("require_down") {
+				c.RequireDown = requireDown
+			}
+			problems, err := pub.Validate(c)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			for _, p := range problems {
+				_, _ = fmt.Fprintln(w, p)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problem(s) in %s", len(problems), c.Path)
+			}
+			_, _ = fmt.Fprintln(w, "Migrations are valid")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&requireDown, "require_down", true, "Report SQL migrations without a Down section")
+	return cmd
+}
+
+func cmdLint(flags *pflag.FlagSet) *cobra.Command {
+	var listRules bool
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Report migrations that lock or rewrite big tables",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			w := cmd.OutOrStdout()
+			if listRules {
+				for _, r := range pub.LintRules() {
+					_, _ = fmt.Fprintf(w, "%-26s %-8s %s\n", r.Name, r.Severity, r.Description)
+				}
+				return nil
+			}
+			c, err := cfg.LoadOffline(flags, cfgFile)
+			if err != nil {
+				return err
+			}
+			findings, err := pub.Lint(c)
+			if err != nil {
+				return err
+			}
+			var errs, warnings int
+			for _, f := range findings {
+				_, _ = fmt.Fprintln(w, f)
+				if f.Severity == pub.SeverityError {
+					errs++
+				} else {
+					warnings++
+				}
+			}
+			if errs > 0 {
+				return fmt.Errorf("lint: %d error(s), %d warning(s)", errs, warnings)
+			}
+			if warnings > 0 {
+				_, _ = fmt.Fprintf(w, "%d warning(s)\n", warnings)
+				return nil
+			}
+			_, _ = fmt.Fprintln(w, "No problems found")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&listRules, "rules", false, "List the lint rules with their default severities")
+	return cmd
+}
+
+func cmdDiff(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the live schema with the schema built by the migrations in a throwaway database",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := loadConfig(flags)
+			if err != nil {
+				return err
+			}
+			drift, err := pub.Diff(cmd.Context(), c)
+			if err != nil {
+				return err
+			}
+			if printSchemaDrift(cmd.OutOrStdout(), drift, "the migrations") {
+				return errors.New("database schema differs from the migrations")
+			}
+			return nil
+		},
+	}
+}
+
+// printSchemaDrift выводит расхождения схемы БД с эталоном ref и сообщает, были ли они.
+func printSchemaDrift(w io.Writer, d pub.SchemaDrift, ref string) bool {
+	if d.FileVersion != d.DBVersion {
+		_, _ = fmt.Fprintf(w, "Version mismatch: %d in %s, %d in the database\n", d.FileVersion, ref, d.DBVersion)
+	}
+	if d.Empty() {
+		_, _ = fmt.Fprintf(w, "Database schema matches %s\n", ref)
+		return false
+	}
+	for _, sec := range []struct {
+		title string
+		stmts []string
+	}{{"Missing in the database (present in " + ref + "):", d.Missing}, {"Unexpected in the database (absent from " + ref + "):", d.Unexpected}} {
+		if len(sec.stmts) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintln(w, sec.title)
+		for _, s := range sec.stmts {
+			_, _ = fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(s, "\n", "\n  "))
+		}
+	}
+	if len(d.Changed) > 0 {
+		_, _ = fmt.Fprintf(w, "Changed in the database (compared to %s):\n", ref)
+		for _, ch := range d.Changed {
+			_, _ = fmt.Fprintf(w, "  %s\n", ch.Object)
+			for _, l := range ch.Removed {
+				_, _ = fmt.Fprintf(w, "    - %s\n", l)
+			}
+			for _, l := range ch.Added {
+				_, _ = fmt.Fprintf(w, "    + %s\n", l)
+			}
+		}
+	}
+	return true
+}
+
+func cmdHistory(flags *pflag.FlagSet) *cobra.Command {
+	var f pub.HistoryFilter
+	cmd := &cobra.Command{Use: "history", Short: "Show the audit log of applied, rolled back and failed migrations", RunE: func(cmd *cobra.Command, _ []string) error {
+		c, err := loadConfig(flags)
+		if err != nil {
+			return err
+		}
+		entries, err := pub.History(cmd.Context(), c, f)
+		if err != nil {
+			return err
+		}
+		return writeHistory(cmd.OutOrStdout(), outputFormat, entries)
+	}}
+	cmd.Flags().Int64Var(&f.Version, "version", 0, "Show entries of this migration version only")
+	cmd.Flags().StringVar(&f.RunID, "run", "", "Show entries of this run ID only")
+	cmd.Flags().IntVar(&f.Limit, "limit", 50, "Maximum number of newest entries to show (0 shows all)")
+	return cmd
+}
+
+// printHistory выводит журнал от новых записей к старым, разделяя колонки табуляцией.
+func printHistory(w io.Writer, entries []pub.HistoryEntry) {
+	_, _ = fmt.Fprintln(w, "TIME	VERSION	ACTION	DIRECTION	DURATION_MS	CHECKSUM	USER@HOST	TOOL	RUN	ERROR")
+	for _, e := range entries {
+		who := e.OSUser + "@" + e.Hostname
+		if who == "@" {
+			who = "-"
+		}
+		msg := e.Error
+		if msg == "" {
+			msg = e.Details
+		}
+		msg = strings.ReplaceAll(msg, "\n", " ")
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format(time.RFC3339), e.Version, e.Action,
+			dash(e.Direction), e.Duration.Milliseconds(), dash(shortChecksum(e.Checksum)), who, dash(e.ToolVersion), dash(e.RunID), msg)
+	}
+}
+
+// dash подставляет "-" вместо пустого значения в табличном выводе.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
 func cmdDBVersion(flags *pflag.FlagSet) *cobra.Command {
@@ -129,15 +520,131 @@ func cmdDBVersion(flags *pflag.FlagSet) *cobra.Command {
 		if err != nil {
 			return err
 		}
-		v, err := pub.DBVersion(context.Background(), c)
+		v, err := pub.DBVersion(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		return writeVersion(cmd.OutOrStdout(), outputFormat, v)
+	}}
+}
+
+func cmdVerify(flags *pflag.FlagSet) *cobra.Command {
+	var accept bool
+	cmd := &cobra.Command{Use: "verify", Short: "Check applied migrations against their checksums", RunE: func(cmd *cobra.Command, _ []string) error {
+		c, err := loadConfig(flags)
+		if err != nil {
+			return err
+		}
+		w := cmd.OutOrStdout()
+		if accept {
+			drifts, err := pub.AcceptChecksums(cmd.Context(), c)
+			if err != nil {
+				return err
+			}
+			for _, d := range drifts {
+				_, _ = fmt.Fprintf(w, "Accepted %d_%s: %s -> %s\n", d.Version, d.Name, d.Stored, d.Actual)
+			}
+			return nil
+		}
+		drifts, err := pub.Verify(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		if len(drifts) == 0 {
+			_, _ = fmt.Fprintln(w, "All applied migrations match their checksums")
+			return nil
+		}
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTORED\tACTUAL")
+		unknown := 0
+		for _, d := range drifts {
+			stored := d.Stored
+			if d.Unknown {
+				stored = "checksum unknown"
+				unknown++
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", d.Version, d.Name, stored, d.Actual)
+		}
+		if unknown > 0 {
+			_, _ = fmt.Fprintf(w, "%d migration(s) were applied before Go migrations had checksums; run `gomigrator verify --accept` to adopt the current checksums\n", unknown)
+		}
+		return fmt.Errorf("%d applied migration(s) differ from their sources", len(drifts))
+	}}
+	cmd.Flags().BoolVar(&accept, "accept", false, "Store the current checksums for drifted migrations")
+	return cmd
+}
+
+func cmdRepair(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{Use: "repair", Short: "Remove records stuck in 'failed' or 'applying' state", RunE: func(cmd *cobra.Command, _ []string) error {
+		c, err := loadConfig(flags)
+		if err != nil {
+			return err
+		}
+		recs, err := pub.Repair(cmd.Context(), c)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprintln(cmd.OutOrStdout(), v)
+		w := cmd.OutOrStdout()
+		if len(recs) == 0 {
+			_, _ = fmt.Fprintln(w, "Nothing to repair")
+			return nil
+		}
+		for _, r := range recs {
+			_, _ = fmt.Fprintf(w, "Removed %s record %d_%s\n", r.Status, r.Version, r.Name)
+		}
 		return nil
 	}}
 }
 
+func cmdForce(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{
+		Use:   "force <version>",
+		Short: "Record all migrations up to version as applied and newer ones as rolled back, without running them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVersionCommand(cmd, flags, args[0], pub.Force, "Forced version %d\n")
+		},
+	}
+}
+
+func cmdMarkApplied(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{
+		Use:   "mark-applied <version>",
+		Short: "Record a migration as applied without running it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVersionCommand(cmd, flags, args[0], pub.MarkApplied, "Marked %d as applied\n")
+		},
+	}
+}
+
+func cmdMarkRolledBack(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{
+		Use:   "mark-rolled-back <version>",
+		Short: "Remove the record of a migration without running its down section",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVersionCommand(cmd, flags, args[0], pub.MarkRolledBack, "Marked %d as rolled back\n")
+		},
+	}
+}
+
+// runVersionCommand разбирает версию из аргумента и вызывает команду восстановления.
+func runVersionCommand(cmd *cobra.Command, flags *pflag.FlagSet, arg string, fn func(context.Context, cfg.Config, int64) error, done string) error {
+	version, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", arg, err)
+	}
+	c, err := loadConfig(flags)
+	if err != nil {
+		return err
+	}
+	if err := fn(cmd.Context(), c, version); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), done, version)
+	return nil
+}
+
 // createSQLTemplate создаёт файл SQL‑миграции с разделителями Up/Down.
 func createSQLTemplate(dir, name string) (string, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -171,6 +678,8 @@ func sanitizeName(s string) string {
 // без дополнительной прослойки времени
 
 // createGoTemplate создаёт шаблон Go‑миграции и регистрирует функции.
+// Файл встраивает собственный исходный текст через go:embed, поэтому контрольная
+// сумма пересчитывается при сборке и меняется при любой правке миграции.
 func createGoTemplate(dir, name string) (string, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", err
@@ -179,33 +688,43 @@ func createGoTemplate(dir, name string) (string, error) {
 	base := fmt.Sprintf("%d_%s.go", ts, sanitizeName(name))
 	full := fmt.Sprintf("%s%c%s", dir, os.PathSeparator, base)
 	pkg := "migrations"
-	content := fmt.Sprintf(`package %s
+	id := fmt.Sprintf("%d_%s", ts, sanitizeName(name))
+	content := fmt.Sprintf(`package %[1]s
 
 import (
     "context"
+    _ "embed"
+
     "github.com/jackc/pgx/v5"
     lib "migrator/pkg/migrator"
 )
 
+// Исходный текст этого файла: контрольная сумма миграции считается по нему при сборке.
+//
+//go:embed %[2]s
+var source_%[3]s []byte
+
 func init() {
-    // Зарегистрировать Go‑миграцию %d_%s
-    _ = lib.Register(%d, "%s", Up_%d_%s, Down_%d_%s)
+    // Зарегистрировать Go‑миграцию %[3]s; ошибка регистрации (повтор версии,
+    // пустая сумма) не должна молча терять миграцию.
+    if err := lib.RegisterContext(%[4]d, "%[5]s", lib.SourceChecksum(source_%[3]s), Up_%[3]s, Down_%[3]s); err != nil {
+        panic(err)
+    }
 }
 
-func Up_%d_%s(tx pgx.Tx) error {
-    // TODO: напишите здесь логику применения (up) миграции
-    // Пример:
-    // _, err := tx.Exec(context.Background(), "SELECT 1")
-    _, _ = tx.Exec(context.Background(), "SELECT 1")
-    return nil
+func Up_%[3]s(ctx context.Context, tx pgx.Tx) error {
+    // TODO: напишите здесь логику применения (up) миграции.
+    // ctx отменяется по SIGINT и таймаутам — передавайте его во все запросы.
+    _, err := tx.Exec(ctx, "SELECT 1")
+    return err
 }
 
-func Down_%d_%s(tx pgx.Tx) error {
+func Down_%[3]s(ctx context.Context, tx pgx.Tx) error {
     // TODO: напишите здесь логику отката (down) миграции
-    _, _ = tx.Exec(context.Background(), "SELECT 1")
-    return nil
+    _, err := tx.Exec(ctx, "SELECT 1")
+    return err
 }
-`, pkg, ts, sanitizeName(name), ts, sanitizeName(name), ts, sanitizeName(name), ts, sanitizeName(name), ts, sanitizeName(name), ts, sanitizeName(name))
+`, pkg, base, id, ts, sanitizeName(name))
 	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
 		return "", err
 	}
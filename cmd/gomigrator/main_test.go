@@ -1,7 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	pub "migrator/pkg/migrator"
 
 	"github.com/spf13/pflag"
 )
@@ -38,4 +50,179 @@ func TestCommandSetup(t *testing.T) {
 	t.Run("CreateStatus", func(_ *testing.T) { _ = cmdStatus(fs) })
 	t.Run("CreateDBVersion", func(_ *testing.T) { _ = cmdDBVersion(fs) })
 	t.Run("CreateCreate", func(_ *testing.T) { _ = cmdCreate(fs) })
+	t.Run("CreateVerify", func(_ *testing.T) { _ = cmdVerify(fs) })
+	t.Run("CreateRepair", func(_ *testing.T) { _ = cmdRepair(fs) })
+	t.Run("CreateForce", func(_ *testing.T) { _ = cmdForce(fs) })
+	t.Run("CreateMarkApplied", func(_ *testing.T) { _ = cmdMarkApplied(fs) })
+	t.Run("CreateMarkRolledBack", func(_ *testing.T) { _ = cmdMarkRolledBack(fs) })
+	t.Run("CreateHistory", func(_ *testing.T) { _ = cmdHistory(fs) })
+	t.Run("CreateCheckSchema", func(_ *testing.T) { _ = cmdCheckSchema(fs) })
+	t.Run("CreateDiff", func(_ *testing.T) { _ = cmdDiff(fs) })
+	t.Run("CreateValidate", func(_ *testing.T) { _ = cmdValidate(fs) })
+	t.Run("CreateLint", func(_ *testing.T) { _ = cmdLint(fs) })
+}
+
+func TestCreateGoTemplate_Checksum(t *testing.T) {
+	dir := t.TempDir()
+	path, err := createGoTemplate(dir, "backfill")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(b)
+	if !strings.Contains(src, "//go:embed "+filepath.Base(path)+"\n") || !strings.Contains(src, "lib.SourceChecksum(source_") {
+		t.Fatalf("template must derive the checksum from its embedded source, got:\n%s", src)
+	}
+	if !strings.Contains(src, "RegisterContext(") || !strings.Contains(src, "panic(err)") || !strings.Contains(src, "(ctx context.Context, tx pgx.Tx) error") {
+		t.Fatalf("template must register a context-aware migration with checksum, got:\n%s", src)
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	var b strings.Builder
+	printPlan(&b, pub.Plan{})
+	if b.String() != "Nothing to do\n" {
+		t.Fatalf("unexpected output for empty plan: %q", b.String())
+	}
+
+	b.Reset()
+	printPlan(&b, pub.Plan{Steps: []pub.PlannedStep{{Version: 1, Name: "init", Kind: "sql", SQL: "CREATE TABLE a(id int);"}}})
+	if !strings.Contains(b.String(), "-- up 1_init (sql)") || !strings.Contains(b.String(), "CREATE TABLE a(id int);") {
+		t.Fatalf("unexpected plan output: %q", b.String())
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	var b strings.Builder
+	printStatus(&b, []pub.StatusRow{
+		{Version: 1, Name: "init", Status: "applied", Checksum: "0123456789abcdef", ExecutionMs: 5},
+		{Version: 2, Name: "late", Status: "pending", OutOfOrder: true},
+		{Version: 3, Name: "broken", Status: "failed", ErrorText: "line1\nline2"},
+	})
+	out := b.String()
+	for _, want := range []string{"applied\t1\tinit\t-\t5\t0123456789ab\t", "pending (out-of-order)\t2", "line1 line2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := exitCode(errors.New("boom")); got != 1 {
+		t.Errorf("expected 1, got %d", got)
+	}
+	if got := exitCode(fmt.Errorf("up: %w", pub.ErrInterrupted)); got != exitInterrupted {
+		t.Errorf("expected %d, got %d", exitInterrupted, got)
+	}
+	// причины, с которыми signalContext останавливает и прерывает запуск
+	for _, again := range []bool{false, true} {
+		if got := exitCode(signalCause(os.Interrupt, again)); got != exitInterrupted {
+			t.Errorf("signal cause (again=%v) must exit with %d, got %d", again, exitInterrupted, got)
+		}
+	}
+}
+
+// syncBuffer — буфер, в который безопасно пишет горутина обработки сигналов.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestSignalContext_TwoStages(t *testing.T) {
+	var out syncBuffer
+	ctx, stop := signalContext(context.Background(), &out)
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for !strings.Contains(out.String(), "stopping after the current migration") {
+		select {
+		case <-deadline:
+			t.Fatal("first signal was not handled")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if ctx.Err() != nil {
+		t.Fatal("first signal must not cancel the in-flight migration")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-deadline:
+		t.Fatal("second signal must cancel the context")
+	}
+	// причина отмены попадает в ошибку, если прерывание случилось вне миграций
+	if got := exitCode(context.Cause(ctx)); got != exitInterrupted {
+		t.Fatalf("abort cause %v must exit with %d, got %d", context.Cause(ctx), exitInterrupted, got)
+	}
+}
+
+func TestPrintLockWait(t *testing.T) {
+	var b strings.Builder
+	printLockWait(&b, []pub.LockHolder{{PID: 42, ApplicationName: "deploy", ClientAddr: "10.0.0.7", Query: "SELECT\n  pg_sleep(600)"}})
+	want := "waiting for the migration lock held by:\n  pid 42, application deploy, client 10.0.0.7, query: SELECT pg_sleep(600)\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant\n%q", b.String(), want)
+	}
+}
+
+func TestPrintSchemaDrift(t *testing.T) {
+	var b strings.Builder
+	if printSchemaDrift(&b, pub.SchemaDrift{FileVersion: 3, DBVersion: 3}, "the schema file") || b.String() != "Database schema matches the schema file\n" {
+		t.Fatalf("unexpected output for matching schema: %q", b.String())
+	}
+	b.Reset()
+	drift := pub.SchemaDrift{FileVersion: 3, DBVersion: 4, Unexpected: []string{"CREATE TABLE public.x (\n    id integer\n);"},
+		Changed: []pub.SchemaChange{{Object: "CREATE TABLE public.users (", Removed: []string{"name text"}, Added: []string{"name varchar(10)"}}}}
+	if !printSchemaDrift(&b, drift, "the migrations") {
+		t.Fatal("drift must be reported")
+	}
+	want := "Version mismatch: 3 in the migrations, 4 in the database\n" +
+		"Unexpected in the database (absent from the migrations):\n  CREATE TABLE public.x (\n      id integer\n  );\n" +
+		"Changed in the database (compared to the migrations):\n  CREATE TABLE public.users (\n    - name text\n    + name varchar(10)\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant\n%q", b.String(), want)
+	}
+}
+
+func TestValidate_RequireDownFlag(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1_up_only.sql"), []byte("-- +migrate Up\nSELECT 1;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) error {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		addCommonFlags(fs)
+		if err := fs.Parse([]string{"--path", dir}); err != nil {
+			t.Fatal(err)
+		}
+		cmd := cmdValidate(fs)
+		cmd.SetOut(io.Discard)
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	}
+	if err := run(); err == nil {
+		t.Fatal("missing Down section must be reported by default")
+	}
+	if err := run("--require_down=false"); err != nil {
+		t.Fatalf("--require_down=false must accept Up-only migrations: %v", err)
+	}
 }
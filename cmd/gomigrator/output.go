@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	pub "migrator/pkg/migrator"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// outputSchemaVersion — версия схемы машиночитаемого вывода.
+// Увеличивается только при несовместимых изменениях полей.
+const outputSchemaVersion = 1
+
+// Форматы вывода, поддерживаемые флагом --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+type statusDoc struct {
+	SchemaVersion int          `json:"schema_version" yaml:"schema_version"`
+	Kind          string       `json:"kind" yaml:"kind"`
+	Migrations    []statusItem `json:"migrations" yaml:"migrations"`
+}
+
+type statusItem struct {
+	Version     int64      `json:"version" yaml:"version"`
+	Name        string     `json:"name" yaml:"name"`
+	Kind        string     `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Status      string     `json:"status" yaml:"status"`
+	OutOfOrder  bool       `json:"out_of_order" yaml:"out_of_order"`
+	Checksum    string     `json:"checksum" yaml:"checksum"`
+	AppliedAt   *time.Time `json:"applied_at" yaml:"applied_at"`
+	ExecutionMs int64      `json:"execution_ms" yaml:"execution_ms"`
+	ErrorText   string     `json:"error_text,omitempty" yaml:"error_text,omitempty"`
+}
+
+type historyDoc struct {
+	SchemaVersion int           `json:"schema_version" yaml:"schema_version"`
+	Kind          string        `json:"kind" yaml:"kind"`
+	Entries       []historyItem `json:"entries" yaml:"entries"`
+}
+
+type historyItem struct {
+	ID          int64     `json:"id" yaml:"id"`
+	Version     int64     `json:"version" yaml:"version"`
+	Action      string    `json:"action" yaml:"action"`
+	Direction   string    `json:"direction,omitempty" yaml:"direction,omitempty"`
+	Checksum    string    `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	DurationMs  int64     `json:"duration_ms" yaml:"duration_ms"`
+	Error       string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Details     string    `json:"details,omitempty" yaml:"details,omitempty"`
+	Hostname    string    `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	OSUser      string    `json:"os_user,omitempty" yaml:"os_user,omitempty"`
+	ToolVersion string    `json:"tool_version,omitempty" yaml:"tool_version,omitempty"`
+	RunID       string    `json:"run_id,omitempty" yaml:"run_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+}
+
+type versionDoc struct {
+	SchemaVersion int    `json:"schema_version" yaml:"schema_version"`
+	Kind          string `json:"kind" yaml:"kind"`
+	Version       int64  `json:"version" yaml:"version"`
+}
+
+type planDoc struct {
+	SchemaVersion int        `json:"schema_version" yaml:"schema_version"`
+	Kind          string     `json:"kind" yaml:"kind"`
+	Direction     string     `json:"direction" yaml:"direction"`
+	Steps         []planItem `json:"steps" yaml:"steps"`
+}
+
+type planItem struct {
+	Version       int64  `json:"version" yaml:"version"`
+	Name          string `json:"name" yaml:"name"`
+	Kind          string `json:"kind" yaml:"kind"`
+	Direction     string `json:"direction" yaml:"direction"`
+	NoTransaction bool   `json:"no_transaction" yaml:"no_transaction"`
+	SQL           string `json:"sql" yaml:"sql"`
+}
+
+// checkOutputFormat проверяет значение флага --output.
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (expected table, json or yaml)", format)
+}
+
+// structuredCommands — команды с машиночитаемым выводом; up и down — только с --dry-run.
+var structuredCommands = map[string]bool{"status": true, "dbversion": true, "history": true, "up": true, "down": true}
+
+// checkOutput проверяет формат --output и отклоняет json/yaml у команд,
+// которые печатают только текст, чтобы скрипт не разбирал текст как документ.
+func checkOutput(cmd *cobra.Command, format string) error {
+	if err := checkOutputFormat(format); err != nil || format == outputTable {
+		return err
+	}
+	supported := structuredCommands[cmd.Name()]
+	if f := cmd.Flags().Lookup("dry-run"); f != nil {
+		supported = supported && f.Value.String() == "true"
+	}
+	if !supported {
+		return fmt.Errorf("--output %s is not supported by %s (supported by status, dbversion, history and up/down --dry-run)", format, cmd.CommandPath())
+	}
+	return nil
+}
+
+// encode сериализует документ в выбранном формате.
+func encode(w io.Writer, format string, doc any) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return checkOutputFormat(format)
+}
+
+func writeStatus(w io.Writer, format string, rows []pub.StatusRow) error {
+	if format == outputTable {
+		printStatus(w, rows)
+		return nil
+	}
+	doc := statusDoc{SchemaVersion: outputSchemaVersion, Kind: "status", Migrations: make([]statusItem, 0, len(rows))}
+	for _, r := range rows {
+		doc.Migrations = append(doc.Migrations, statusItem{
+			Version:     r.Version,
+			Name:        r.Name,
+			Kind:        string(r.Kind),
+			Status:      r.Status,
+			OutOfOrder:  r.OutOfOrder,
+			Checksum:    r.Checksum,
+			AppliedAt:   r.AppliedAt,
+			ExecutionMs: r.ExecutionMs,
+			ErrorText:   r.ErrorText,
+		})
+	}
+	return encode(w, format, doc)
+}
+
+func writeHistory(w io.Writer, format string, entries []pub.HistoryEntry) error {
+	if format == outputTable {
+		printHistory(w, entries)
+		return nil
+	}
+	doc := historyDoc{SchemaVersion: outputSchemaVersion, Kind: "history", Entries: make([]historyItem, 0, len(entries))}
+	for _, e := range entries {
+		doc.Entries = append(doc.Entries, historyItem{
+			ID:          e.ID,
+			Version:     e.Version,
+			Action:      e.Action,
+			Direction:   e.Direction,
+			Checksum:    e.Checksum,
+			DurationMs:  e.Duration.Milliseconds(),
+			Error:       e.Error,
+			Details:     e.Details,
+			Hostname:    e.Hostname,
+			OSUser:      e.OSUser,
+			ToolVersion: e.ToolVersion,
+			RunID:       e.RunID,
+			CreatedAt:   e.CreatedAt,
+		})
+	}
+	return encode(w, format, doc)
+}
+
+func writeVersion(w io.Writer, format string, v int64) error {
+	if format == outputTable {
+		_, _ = fmt.Fprintln(w, v)
+		return nil
+	}
+	return encode(w, format, versionDoc{SchemaVersion: outputSchemaVersion, Kind: "dbversion", Version: v})
+}
+
+func writePlan(w io.Writer, format string, plan pub.Plan) error {
+	if format == outputTable {
+		printPlan(w, plan)
+		return nil
+	}
+	doc := planDoc{SchemaVersion: outputSchemaVersion, Kind: "plan", Direction: plan.Direction.String(), Steps: make([]planItem, 0, len(plan.Steps))}
+	for _, s := range plan.Steps {
+		doc.Steps = append(doc.Steps, planItem{
+			Version:       s.Version,
+			Name:          s.Name,
+			Kind:          string(s.Kind),
+			Direction:     s.Direction.String(),
+			NoTransaction: s.NoTransaction,
+			SQL:           s.SQL,
+		})
+	}
+	return encode(w, format, doc)
+}
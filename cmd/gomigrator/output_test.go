@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	pub "migrator/pkg/migrator"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteStatus_JSON(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rows := []pub.StatusRow{
+		{Version: 1, Name: "init", Kind: "sql", Status: "applied", Checksum: "abc", AppliedAt: &at, ExecutionMs: 7},
+		{Version: 2, Name: "next", Kind: "go", Status: "pending"},
+	}
+	var b strings.Builder
+	if err := writeStatus(&b, outputJSON, rows); err != nil {
+		t.Fatal(err)
+	}
+	var doc statusDoc
+	if err := json.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, b.String())
+	}
+	if doc.SchemaVersion != outputSchemaVersion || doc.Kind != "status" || len(doc.Migrations) != 2 {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+	if doc.Migrations[0].AppliedAt == nil || !doc.Migrations[0].AppliedAt.Equal(at) || doc.Migrations[0].ExecutionMs != 7 {
+		t.Fatalf("unexpected applied row: %+v", doc.Migrations[0])
+	}
+	if !strings.Contains(b.String(), `"applied_at": null`) {
+		t.Fatalf("pending rows must keep applied_at as null:\n%s", b.String())
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	entries := []pub.HistoryEntry{
+		{ID: 2, Version: 1, Action: "failed", Direction: "up", Duration: 1500 * time.Millisecond, Error: "lock\ntimeout", OSUser: "deploy", Hostname: "ci", RunID: "r1", CreatedAt: at},
+		{ID: 1, Version: 1, Action: "repair", Details: "removed failed row", CreatedAt: at},
+	}
+	var b strings.Builder
+	if err := writeHistory(&b, outputTable, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "failed\tup\t1500") || !strings.Contains(lines[1], "deploy@ci") || !strings.HasSuffix(lines[1], "lock timeout") {
+		t.Fatalf("unexpected table output:\n%s", b.String())
+	}
+	if !strings.HasSuffix(lines[2], "removed failed row") {
+		t.Fatalf("details must be shown when there is no error:\n%s", b.String())
+	}
+	b.Reset()
+	if err := writeHistory(&b, outputJSON, entries); err != nil {
+		t.Fatal(err)
+	}
+	var doc historyDoc
+	if err := json.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, b.String())
+	}
+	if doc.Kind != "history" || len(doc.Entries) != 2 || doc.Entries[0].DurationMs != 1500 || doc.Entries[0].RunID != "r1" {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+}
+
+func TestWriteVersion(t *testing.T) {
+	var b strings.Builder
+	if err := writeVersion(&b, outputTable, 42); err != nil || b.String() != "42\n" {
+		t.Fatalf("unexpected table output %q (%v)", b.String(), err)
+	}
+	b.Reset()
+	if err := writeVersion(&b, outputYAML, 42); err != nil {
+		t.Fatal(err)
+	}
+	var doc versionDoc
+	if err := yaml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 42 || doc.Kind != "dbversion" || doc.SchemaVersion != outputSchemaVersion {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+}
+
+func TestWritePlan_YAML(t *testing.T) {
+	plan := pub.Plan{Direction: pub.DirectionDown, Steps: []pub.PlannedStep{{Version: 3, Name: "drop", Kind: "sql", Direction: pub.DirectionDown, SQL: "DROP TABLE a;"}}}
+	var b strings.Builder
+	if err := writePlan(&b, outputYAML, plan); err != nil {
+		t.Fatal(err)
+	}
+	var doc planDoc
+	if err := yaml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Direction != "down" || len(doc.Steps) != 1 || doc.Steps[0].SQL != "DROP TABLE a;" {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	for _, f := range []string{outputTable, outputJSON, outputYAML} {
+		if err := checkOutputFormat(f); err != nil {
+			t.Errorf("format %q must be accepted: %v", f, err)
+		}
+	}
+	if err := checkOutputFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	command := func(name string, dryRun bool) *cobra.Command {
+		cmd := &cobra.Command{Use: name}
+		if name == "up" || name == "down" {
+			cmd.Flags().Bool("dry-run", dryRun, "")
+		}
+		return cmd
+	}
+	for _, name := range []string{"status", "dbversion", "history"} {
+		if err := checkOutput(command(name, false), outputJSON); err != nil {
+			t.Errorf("%s must accept json: %v", name, err)
+		}
+	}
+	if err := checkOutput(command("up", true), outputYAML); err != nil {
+		t.Errorf("up --dry-run must accept yaml: %v", err)
+	}
+	for _, name := range []string{"up", "down", "verify", "validate", "lint", "check-schema"} {
+		if err := checkOutput(command(name, false), outputJSON); err == nil {
+			t.Errorf("%s must reject json", name)
+		}
+		if err := checkOutput(command(name, false), outputTable); err != nil {
+			t.Errorf("%s must accept table: %v", name, err)
+		}
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	icfg "migrator/internal/config"
+	"migrator/internal/driver/postgres"
+	im "migrator/internal/migrator"
 	pub "migrator/pkg/migrator"
 )
 
@@ -99,6 +103,107 @@ DELETE FROM foo WHERE id=1;`)
 	}
 }
 
+func Test_SchemaSnapshot(t *testing.T) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn())
+	if err != nil {
+		t.Skipf("pg not available: %v", err)
+	}
+	defer pool.Close()
+	if err := pool.Ping(ctx); err != nil {
+		t.Skipf("pg not available: %v", err)
+	}
+
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "2_snap.sql"), `-- +migrate Up
+CREATE TYPE snap_mood AS ENUM ('ok', 'sad');
+CREATE TABLE snap_t(id BIGSERIAL PRIMARY KEY, mood snap_mood NOT NULL DEFAULT 'ok', name text);
+CREATE INDEX snap_t_name_idx ON snap_t(name);
+CREATE VIEW snap_v AS SELECT id FROM snap_t;
+
+-- +migrate Down
+DROP VIEW snap_v;
+DROP TABLE snap_t;
+DROP TYPE snap_mood;`)
+	schemaFile := filepath.Join(dir, "schema.sql")
+	cfg := icfg.Config{DSN: dsn(), Path: dir, Kind: "sql", LockKey: 7243393, SchemaTable: "snapshot_migrations", SchemaFile: schemaFile}
+	defer func() { _ = pub.RunDownTo(ctx, cfg, 0) }()
+
+	if err := pub.RunUp(ctx, cfg); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	snap, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-- Migration version: 2", "CREATE TYPE public.snap_mood AS ENUM ('ok', 'sad');",
+		"CREATE TABLE public.snap_t (", "CREATE INDEX snap_t_name_idx", "CREATE VIEW public.snap_v AS"} {
+		if !strings.Contains(string(snap), want) {
+			t.Fatalf("snapshot misses %q:\n%s", want, snap)
+		}
+	}
+	if strings.Contains(string(snap), "snapshot_migrations") {
+		t.Fatalf("snapshot must not include the migrator's own tables:\n%s", snap)
+	}
+	drift, err := pub.CheckSchema(ctx, cfg)
+	if err != nil || !drift.Empty() {
+		t.Fatalf("expected no drift right after up, got %+v (%v)", drift, err)
+	}
+
+	// ручное изменение в обход миграций
+	if _, err := pool.Exec(ctx, "ALTER TABLE snap_t ADD COLUMN hotfix int"); err != nil {
+		t.Fatal(err)
+	}
+	drift, err = pub.CheckSchema(ctx, cfg)
+	if err != nil || len(drift.Changed) != 1 || len(drift.Changed[0].Added) != 1 || drift.Changed[0].Added[0] != "hotfix integer" {
+		t.Fatalf("expected drift of snap_t, got %+v (%v)", drift, err)
+	}
+	// старая неприменённая миграция не должна попасть во временную БД
+	mustWrite(t, filepath.Join(dir, "1_late.sql"), `-- +migrate Up
+CREATE TABLE snap_late(id int);
+
+-- +migrate Down
+DROP TABLE snap_late;`)
+	// diff строит ту же схему из миграций во временной БД
+	drift, err = pub.Diff(ctx, cfg)
+	if err != nil {
+		t.Skipf("diff needs CREATEDB: %v", err)
+	}
+	if len(drift.Changed) != 1 || drift.Changed[0].Object != "CREATE TABLE public.snap_t (" {
+		t.Fatalf("expected hotfix column to be reported by diff, got %+v", drift)
+	}
+	if _, err := pool.Exec(ctx, "ALTER TABLE snap_t DROP COLUMN hotfix"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_GoNoTx_SmallPool(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	sep := "?"
+	if strings.Contains(dsn(), "?") {
+		sep = "&"
+	}
+	// соединение блокировки плюс соединение самой миграции — весь пул
+	db, err := postgres.Connect(ctx, dsn()+sep+"pool_max_conns=2", "pool_migrations", 7243394)
+	if err != nil {
+		t.Skipf("pg not available: %v", err)
+	}
+	defer db.Close()
+	defer func() {
+		_, _ = db.Pool.Exec(context.Background(), "DROP TABLE IF EXISTS pool_migrations, pool_migrations_history")
+	}()
+
+	step := im.Step{Version: 1, Name: "batch", Kind: im.KindGo, Checksum: "sum", NoTransaction: true,
+		UpPoolFn: func(ctx context.Context, pool *pgxpool.Pool) error {
+			_, err := pool.Exec(ctx, "SELECT 1")
+			return err
+		}}
+	if err := im.NewRunner(db).Up(ctx, []im.Step{step}); err != nil {
+		t.Fatalf("up with pool_max_conns=2 failed: %v", err)
+	}
+}
+
 func mustWrite(t *testing.T, path string, s string) {
 	t.Helper()
 	if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
@@ -4,12 +4,16 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+
+	"migrator/internal/migrator"
 )
 
 // Config содержит конфигурацию приложения
@@ -19,20 +23,62 @@ type Config struct {
 	Kind        string `mapstructure:"kind"` // sql|go
 	LockKey     int64  `mapstructure:"lock_key"`
 	SchemaTable string `mapstructure:"schema_table"`
+	// AllowOutOfOrder разрешает применять миграции старше последней примененной версии
+	AllowOutOfOrder bool `mapstructure:"allow_out_of_order"`
+	// LockWaitTimeout ограничивает ожидание блокировки мигратора; 0 — ждать без ограничения
+	LockWaitTimeout time.Duration `mapstructure:"lock_wait_timeout"`
+	// StatementTimeout и LockTimeout по умолчанию выставляются каждой миграции (SET LOCAL);
+	// 0 — не менять настройки БД. Файл миграции может переопределить их директивой
+	StatementTimeout time.Duration `mapstructure:"statement_timeout"`
+	LockTimeout      time.Duration `mapstructure:"lock_timeout"`
+	// RetryAttempts — число попыток миграции при lock_not_available, deadlock_detected и
+	// serialization_failure; 0 или 1 — без повторов. Пауза растет от RetryBackoff до RetryMaxBackoff
+	RetryAttempts   int           `mapstructure:"retry_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	RetryMaxBackoff time.Duration `mapstructure:"retry_max_backoff"`
+	// RetryJitter — доля случайного разброса паузы (0.2 — ±20%)
+	RetryJitter float64 `mapstructure:"retry_jitter"`
+	// SchemaFile — файл снимка схемы, перезаписываемый после up/down/redo; пусто — не писать
+	SchemaFile string `mapstructure:"schema_file"`
+	// RequireDown заставляет validate требовать непустую секцию Down в каждой SQL-миграции
+	RequireDown bool `mapstructure:"require_down"`
+	// Lint переопределяет уровни правил lint: имя правила -> error|warning|off
+	Lint map[string]string `mapstructure:"lint"`
+	// FS — источник SQL-миграций вместо Path (например, embed.FS); задается только из кода
+	FS fs.FS `mapstructure:"-"`
+	// OnLockWait вызывается, если блокировка занята, со списком удерживающих ее сессий
+	OnLockWait func([]migrator.LockHolder) `mapstructure:"-"`
 }
 
 // Default returns the default configuration.
 func Default() Config {
 	return Config{
-		Path:        "./migrations",
-		Kind:        "sql",
-		LockKey:     7243392,
-		SchemaTable: "schema_migrations",
+		Path:            "./migrations",
+		Kind:            "sql",
+		LockKey:         7243392,
+		SchemaTable:     "schema_migrations",
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 30 * time.Second,
+		RetryJitter:     0.2,
+		RequireDown:     true,
 	}
 }
 
 // Load загружает конфигурацию из файла + переменных окружения + флагов
 func Load(flags *pflag.FlagSet, configFile string) (Config, error) {
+	c, err := LoadOffline(flags, configFile)
+	if err != nil {
+		return Config{}, err
+	}
+	if c.DSN == "" {
+		return Config{}, fmt.Errorf("dsn is required (env GOMIGRATOR_DSN or config dsn)")
+	}
+	return c, nil
+}
+
+// LoadOffline loads the configuration like Load but does not require a DSN,
+// for commands that only read migration files.
+func LoadOffline(flags *pflag.FlagSet, configFile string) (Config, error) {
 	v := viper.New()
 	v.SetConfigType("yaml")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -41,11 +87,21 @@ func Load(flags *pflag.FlagSet, configFile string) (Config, error) {
 
 	def := Default()
 	_ = v.MergeConfigMap(map[string]any{
-		"dsn":          def.DSN,
-		"path":         def.Path,
-		"kind":         def.Kind,
-		"lock_key":     def.LockKey,
-		"schema_table": def.SchemaTable,
+		"dsn":                def.DSN,
+		"path":               def.Path,
+		"kind":               def.Kind,
+		"lock_key":           def.LockKey,
+		"schema_table":       def.SchemaTable,
+		"allow_out_of_order": def.AllowOutOfOrder,
+		"lock_wait_timeout":  def.LockWaitTimeout,
+		"statement_timeout":  def.StatementTimeout,
+		"lock_timeout":       def.LockTimeout,
+		"retry_attempts":     def.RetryAttempts,
+		"retry_backoff":      def.RetryBackoff,
+		"retry_max_backoff":  def.RetryMaxBackoff,
+		"retry_jitter":       def.RetryJitter,
+		"schema_file":        def.SchemaFile,
+		"require_down":       def.RequireDown,
 	})
 
 	if configFile != "" {
@@ -72,9 +128,6 @@ func Load(flags *pflag.FlagSet, configFile string) (Config, error) {
 	if err := v.Unmarshal(&c); err != nil {
 		return Config{}, err
 	}
-	if c.DSN == "" {
-		return Config{}, fmt.Errorf("dsn is required (env GOMIGRATOR_DSN or config dsn)")
-	}
 	if c.Path == "" {
 		c.Path = def.Path
 	}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -45,6 +46,13 @@ func TestLoad(t *testing.T) {
 		}
 	})
 
+	t.Run("offline without dsn", func(t *testing.T) {
+		c, err := LoadOffline(nil, "")
+		if err != nil || c.DSN != "" || !c.RequireDown {
+			t.Fatalf("unexpected offline config %+v (%v)", c, err)
+		}
+	})
+
 	t.Run("from config file", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		cfgPath := filepath.Join(tmpDir, "config.yaml")
@@ -52,6 +60,12 @@ func TestLoad(t *testing.T) {
 dsn: "postgres://file:5432/db"
 path: "./custom_migrations"
 kind: "go"
+allow_out_of_order: true
+lock_wait_timeout: 45s
+statement_timeout: 10m
+lock_timeout: 5s
+lint:
+  drop-in-up: error
 `
 		if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write tmp config: %v", err)
@@ -67,14 +81,27 @@ kind: "go"
 		if c.Kind != "go" {
 			t.Errorf("unexpected kind: %s", c.Kind)
 		}
+		if !c.AllowOutOfOrder {
+			t.Error("expected allow_out_of_order to be read from file")
+		}
+		if c.LockWaitTimeout != 45*time.Second {
+			t.Errorf("unexpected lock_wait_timeout: %s", c.LockWaitTimeout)
+		}
+		if c.StatementTimeout != 10*time.Minute || c.LockTimeout != 5*time.Second {
+			t.Errorf("unexpected timeouts: statement %s, lock %s", c.StatementTimeout, c.LockTimeout)
+		}
+		if c.Lint["drop-in-up"] != "error" {
+			t.Errorf("unexpected lint severities: %v", c.Lint)
+		}
 	})
 
 	t.Run("with flags", func(t *testing.T) {
 		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
 		fs.String("dsn", "", "")
 		fs.String("kind", "", "")
+		fs.Duration("lock_wait_timeout", 0, "")
 
-		err := fs.Parse([]string{"--dsn", "postgres://flag:5432/db", "--kind", "sql"})
+		err := fs.Parse([]string{"--dsn", "postgres://flag:5432/db", "--kind", "sql", "--lock_wait_timeout", "2m"})
 		if err != nil {
 			t.Fatalf("failed to parse flags: %v", err)
 		}
@@ -89,5 +116,8 @@ kind: "go"
 		if c.Kind != "sql" {
 			t.Errorf("unexpected kind: %s", c.Kind)
 		}
+		if c.LockWaitTimeout != 2*time.Minute {
+			t.Errorf("unexpected lock_wait_timeout: %s", c.LockWaitTimeout)
+		}
 	})
 }
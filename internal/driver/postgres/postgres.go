@@ -3,10 +3,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrator/internal/migrator"
 )
 
 // DB is a wrapper over the PostgreSQL connection pool.
@@ -14,30 +19,52 @@ type DB struct {
 	Pool        *pgxpool.Pool
 	SchemaTable string
 	LockKey     int64
+	// Lock configures waiting for the advisory lock.
+	Lock migrator.LockOptions
 }
 
 // Connect creates a new database connection and initializes service tables.
 func Connect(ctx context.Context, dsn, schemaTable string, lockKey int64) (*DB, error) {
-	cfg, err := pgxpool.ParseConfig(dsn)
+	db, err := Open(ctx, dsn, schemaTable, lockKey)
 	if err != nil {
 		return nil, err
 	}
-	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err := db.EnsureTables(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open creates the connection pool without touching the service tables,
+// for read-only commands such as dry runs.
+func Open(ctx context.Context, dsn, schemaTable string, lockKey int64) (*DB, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{Pool: pool, SchemaTable: schemaTable, LockKey: lockKey}
-	if err := db.ensureTables(ctx); err != nil {
-		pool.Close()
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
 		return nil, err
 	}
-	return db, nil
+	return &DB{Pool: pool, SchemaTable: schemaTable, LockKey: lockKey}, nil
+}
+
+// SchemaTableExists reports whether the schema table has been created.
+func (d *DB) SchemaTableExists(ctx context.Context) (bool, error) {
+	var ok bool
+	err := d.Pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", d.SchemaTable).Scan(&ok)
+	return ok, err
 }
 
+// HistoryTable returns the name of the audit table kept next to the schema table.
+func (d *DB) HistoryTable() string { return d.SchemaTable + "_history" }
+
 // Close closes the connection pool.
 func (d *DB) Close() { d.Pool.Close() }
 
-func (d *DB) ensureTables(ctx context.Context) error {
+// EnsureTables creates the schema and history tables if they do not exist.
+func (d *DB) EnsureTables(ctx context.Context) error {
 	sql := fmt.Sprintf(`
 CREATE TABLE IF NOT EXISTS %s (
     id              BIGSERIAL PRIMARY KEY,
@@ -51,12 +78,28 @@ CREATE TABLE IF NOT EXISTS %s (
     error_text      TEXT
 );
 CREATE UNIQUE INDEX IF NOT EXISTS %s_version_uq ON %s (version);
-`, d.SchemaTable, d.SchemaTable, d.SchemaTable)
+CREATE TABLE IF NOT EXISTS %s (
+    id              BIGSERIAL PRIMARY KEY,
+    version         BIGINT NOT NULL,
+    action          TEXT NOT NULL,
+    direction       TEXT,
+    checksum        TEXT,
+    duration_ms     BIGINT,
+    error_text      TEXT,
+    details         TEXT,
+    hostname        TEXT,
+    os_user         TEXT,
+    tool_version    TEXT,
+    run_id          TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`, d.SchemaTable, d.SchemaTable, d.SchemaTable, d.HistoryTable())
 	_, err := d.Pool.Exec(ctx, sql)
 	return err
 }
 
 // WithAdvisoryLock executes the given function within a PostgreSQL advisory lock.
+// The lock is polled with pg_try_advisory_lock, so the wait honours d.Lock.Timeout.
 func (d *DB) WithAdvisoryLock(ctx context.Context, fn func(context.Context) error) error {
 	// session-level lock using a dedicated connection
 	conn, err := d.Pool.Acquire(ctx)
@@ -64,35 +107,225 @@ func (d *DB) WithAdvisoryLock(ctx context.Context, fn func(context.Context) erro
 		return err
 	}
 	defer conn.Release()
-	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", d.LockKey); err != nil {
+	try := func(ctx context.Context) (bool, error) {
+		var ok bool
+		err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", d.LockKey).Scan(&ok)
+		return ok, err
+	}
+	holders := func(ctx context.Context) ([]migrator.LockHolder, error) {
+		return lockHolders(ctx, conn, d.LockKey)
+	}
+	if err := migrator.AcquireLock(ctx, d.Lock, try, holders); err != nil {
 		return err
 	}
 	defer func() {
-		_, _ = conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", d.LockKey)
+		// контекст может быть уже отменён сигналом; блокировку нужно снять всё равно,
+		// иначе соединение вернётся в пул с удерживаемой блокировкой
+		bg := context.WithoutCancel(ctx)
+		if _, err := conn.Exec(bg, "SELECT pg_advisory_unlock($1)", d.LockKey); err != nil {
+			_ = conn.Conn().Close(bg)
+		}
 	}()
 	return fn(ctx)
 }
 
+// lockHolders ищет в pg_locks сессии, удерживающие advisory-блокировку с ключом key.
+// Ключ bigint хранится как classid (старшие 32 бита) и objid (младшие) с objsubid = 1.
+func lockHolders(ctx context.Context, q querier, key int64) ([]migrator.LockHolder, error) {
+	rows, err := q.Query(ctx, `SELECT a.pid, COALESCE(a.usename, ''), COALESCE(a.application_name, ''),
+       COALESCE(host(a.client_addr), ''), COALESCE(a.state, ''), COALESCE(a.query, ''), a.query_start
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+  AND l.classid::bigint = (($1::bigint >> 32) & 4294967295)
+  AND l.objid::bigint = ($1::bigint & 4294967295)
+  AND a.pid <> pg_backend_pid()`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []migrator.LockHolder
+	for rows.Next() {
+		var h migrator.LockHolder
+		if err := rows.Scan(&h.PID, &h.User, &h.ApplicationName, &h.ClientAddr, &h.State, &h.Query, &h.QueryStart); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// LoadHistory returns history entries matching f, newest first.
+func (d *DB) LoadHistory(ctx context.Context, f migrator.HistoryFilter) ([]migrator.HistoryEntry, error) {
+	q, args := migrator.HistoryQuery(d.HistoryTable(), f, func(i int) string { return fmt.Sprintf("$%d", i) })
+	rows, err := d.Pool.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []migrator.HistoryEntry
+	for rows.Next() {
+		e, err := migrator.ScanHistory(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// LoadRecords returns all rows of the schema table ordered by version.
+func (d *DB) LoadRecords(ctx context.Context) ([]Record, error) {
+	rows, err := d.Pool.Query(ctx, fmt.Sprintf(`SELECT version, name, checksum, status, applied_at, updated_at, COALESCE(execution_ms, 0), error_text
+FROM %s ORDER BY version`, d.SchemaTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.Version, &rec.Name, &rec.Checksum, &rec.Status, &rec.AppliedAt, &rec.UpdatedAt, &rec.ExecutionMs, &rec.ErrorText); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// InTx runs fn in a transaction, committing on success and rolling back on error.
+func (d *DB) InTx(ctx context.Context, fn func(migrator.Conn) error) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(&conn{db: d, q: tx, tx: tx}); err != nil {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// WithConn runs fn on a single pooled connection outside a transaction.
+func (d *DB) WithConn(ctx context.Context, fn func(migrator.Conn) error) error {
+	c, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+	cc := &conn{db: d, q: c}
+	defer func() {
+		if cc.timeoutsSet {
+			// соединение вернётся в пул: сессионные таймауты миграции не должны утечь
+			bg := context.WithoutCancel(ctx)
+			_, _ = c.Exec(bg, "RESET statement_timeout")
+			_, _ = c.Exec(bg, "RESET lock_timeout")
+		}
+	}()
+	return fn(cc)
+}
+
+// querier — общее подмножество pgx.Tx и *pgxpool.Conn.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// conn реализует migrator.Conn поверх транзакции или отдельного соединения.
+type conn struct {
+	db *DB
+	q  querier
+	tx pgx.Tx
+	// timeoutsSet — таймауты выставлены на уровне сессии и требуют сброса.
+	timeoutsSet bool
+}
+
+// PgxTx returns the underlying transaction, or nil outside a transaction.
+func (c *conn) PgxTx() pgx.Tx { return c.tx }
+
+// PgxPool returns the driver's connection pool.
+func (c *conn) PgxPool() *pgxpool.Pool { return c.db.Pool }
+
+// SetTimeouts sets statement_timeout and lock_timeout with SET LOCAL inside a
+// transaction, or for the session otherwise (reset when the connection is released).
+func (c *conn) SetTimeouts(ctx context.Context, statement, lock time.Duration) error {
+	scope := "LOCAL"
+	if c.tx == nil {
+		scope = "SESSION"
+		c.timeoutsSet = true
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{{"statement_timeout", statement}, {"lock_timeout", lock}} {
+		if t.d == 0 {
+			continue
+		}
+		ms := max(t.d.Milliseconds(), 0) // TimeoutOff → 0, таймаут отключён
+		if _, err := c.q.Exec(ctx, fmt.Sprintf("SET %s %s = %d", scope, t.name, ms)); err != nil {
+			return fmt.Errorf("set %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+func (c *conn) Exec(ctx context.Context, sql string) error {
+	_, err := c.q.Exec(ctx, sql)
+	return err
+}
+
+func (c *conn) GetRecord(ctx context.Context, version int64) (Record, bool, error) {
+	rec := Record{Version: version}
+	err := c.q.QueryRow(ctx, fmt.Sprintf(`SELECT name, checksum, status, applied_at, updated_at, COALESCE(execution_ms, 0), error_text
+FROM %s WHERE version=$1`, c.db.SchemaTable), version).
+		Scan(&rec.Name, &rec.Checksum, &rec.Status, &rec.AppliedAt, &rec.UpdatedAt, &rec.ExecutionMs, &rec.ErrorText)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	return rec, true, nil
+}
+
+func (c *conn) SaveRecord(ctx context.Context, rec Record) error {
+	q := fmt.Sprintf(`INSERT INTO %s(version,name,checksum,status,applied_at,updated_at,execution_ms,error_text)
+VALUES($1,$2,$3,$4,$5,now(),$6,$7)
+ON CONFLICT (version) DO UPDATE SET name=EXCLUDED.name, checksum=EXCLUDED.checksum, status=EXCLUDED.status,
+applied_at=EXCLUDED.applied_at, updated_at=now(), execution_ms=EXCLUDED.execution_ms, error_text=EXCLUDED.error_text`, c.db.SchemaTable)
+	_, err := c.q.Exec(ctx, q, rec.Version, rec.Name, rec.Checksum, string(rec.Status), rec.AppliedAt, rec.ExecutionMs, rec.ErrorText)
+	return err
+}
+
+func (c *conn) DeleteRecord(ctx context.Context, version int64) error {
+	_, err := c.q.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=$1", c.db.SchemaTable), version)
+	return err
+}
+
+func (c *conn) AddHistory(ctx context.Context, e migrator.HistoryEntry) error {
+	_, err := c.q.Exec(ctx, fmt.Sprintf(`INSERT INTO %s(version,action,direction,checksum,duration_ms,error_text,details,hostname,os_user,tool_version,run_id)
+VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`, c.db.HistoryTable()),
+		e.Version, e.Action, e.Direction, e.Checksum, e.Duration.Milliseconds(), e.Error, e.Details, e.Hostname, e.OSUser, e.ToolVersion, e.RunID)
+	return err
+}
+
 // MigrationStatus represents the status of a migration.
-type MigrationStatus string
+type MigrationStatus = migrator.MigrationStatus
 
 const (
 	// StatusApplied indicates the migration has been successfully applied.
-	StatusApplied MigrationStatus = "applied"
+	StatusApplied = migrator.StatusApplied
 	// StatusApplying indicates the migration is currently being applied.
-	StatusApplying MigrationStatus = "applying"
+	StatusApplying = migrator.StatusApplying
 	// StatusFailed indicates the migration attempt failed.
-	StatusFailed MigrationStatus = "failed"
+	StatusFailed = migrator.StatusFailed
 )
 
 // Record represents a migration record in the database.
-type Record struct {
-	Version     int64
-	Name        string
-	Checksum    string
-	Status      MigrationStatus
-	AppliedAt   *time.Time
-	UpdatedAt   time.Time
-	ExecutionMs int64
-	ErrorText   *string
-}
+type Record = migrator.Record
+
+var _ migrator.Driver = (*DB)(nil)
+var _ migrator.TimeoutSetter = (*conn)(nil)
+var _ migrator.ScratchDatabaser = (*DB)(nil)
+var _ migrator.SchemaSnapshotter = (*DB)(nil)
+var _ migrator.TableChecker = (*DB)(nil)
@@ -24,3 +24,10 @@ func TestMigrationStatus(t *testing.T) {
 		t.Errorf("expected failed, got %s", StatusFailed)
 	}
 }
+
+func TestHistoryTable(t *testing.T) {
+	db := &DB{SchemaTable: "schema_migrations"}
+	if got := db.HistoryTable(); got != "schema_migrations_history" {
+		t.Errorf("expected schema_migrations_history, got %s", got)
+	}
+}
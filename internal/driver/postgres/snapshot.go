@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrator/internal/migrator"
+)
+
+// Фильтры запросов снимка: системные схемы, объекты расширений и собственные
+// таблицы мигратора (передаются параметром $1 как text[]) в снимок не попадают.
+const (
+	userNamespace = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'`
+	notMigrator   = `c.oid NOT IN (SELECT to_regclass(u)::oid FROM unnest($1::text[]) u WHERE to_regclass(u) IS NOT NULL)`
+)
+
+// notExtension исключает объекты каталога catalog, принадлежащие расширениям.
+func notExtension(catalog, oid string) string {
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = '%s'::regclass AND d.objid = %s AND d.deptype = 'e')`, catalog, oid)
+}
+
+// snapshotQueries возвращают по одному DDL-выражению в строке, в порядке,
+// в котором объекты можно создать. Сортировка по именам делает снимок детерминированным.
+var snapshotQueries = []string{
+	// расширения
+	`SELECT format('CREATE EXTENSION IF NOT EXISTS %I;', extname) FROM pg_extension
+WHERE extname <> 'plpgsql' ORDER BY extname`,
+	// схемы
+	`SELECT format('CREATE SCHEMA %I;', n.nspname) FROM pg_namespace n
+WHERE ` + userNamespace + ` AND n.nspname <> 'public' AND ` + notExtension("pg_namespace", "n.oid") + ` ORDER BY n.nspname`,
+	// перечисления
+	`SELECT format('CREATE TYPE %I.%I AS ENUM (%s);', n.nspname, t.typname,
+    (SELECT string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid))
+FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE t.typtype = 'e' AND ` + userNamespace + ` AND ` + notExtension("pg_type", "t.oid") + ` ORDER BY n.nspname, t.typname`,
+	// домены
+	`SELECT format('CREATE DOMAIN %I.%I AS %s%s%s;', n.nspname, t.typname, format_type(t.typbasetype, t.typtypmod),
+    CASE WHEN t.typnotnull THEN ' NOT NULL' ELSE '' END,
+    COALESCE((SELECT string_agg(format(' CONSTRAINT %I %s', con.conname, pg_get_constraintdef(con.oid)), '' ORDER BY con.conname)
+        FROM pg_constraint con WHERE con.contypid = t.oid AND con.contype = 'c'), ''))
+FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE t.typtype = 'd' AND ` + userNamespace + ` AND ` + notExtension("pg_type", "t.oid") + ` ORDER BY n.nspname, t.typname`,
+	// последовательности, не принадлежащие колонкам (serial и identity описываются в таблице)
+	`SELECT format('CREATE SEQUENCE %I.%I;', n.nspname, c.relname)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'S' AND ` + userNamespace + ` AND ` + notMigrator + `
+AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('a', 'i', 'e'))
+ORDER BY n.nspname, c.relname`,
+	// таблицы с колонками в физическом порядке; секции партиционированных таблиц не включаются
+	`SELECT format(E'CREATE TABLE %I.%I (\n%s\n)%s;', n.nspname, c.relname,
+    COALESCE((SELECT string_agg(format('    %I %s%s%s%s', a.attname, format_type(a.atttypid, a.atttypmod),
+            CASE a.attidentity WHEN 'a' THEN ' GENERATED ALWAYS AS IDENTITY' WHEN 'd' THEN ' GENERATED BY DEFAULT AS IDENTITY' ELSE '' END,
+            CASE WHEN a.attgenerated = 's' THEN ' GENERATED ALWAYS AS (' || pg_get_expr(ad.adbin, ad.adrelid) || ') STORED'
+                 WHEN ad.adbin IS NOT NULL THEN ' DEFAULT ' || pg_get_expr(ad.adbin, ad.adrelid) ELSE '' END,
+            CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END), E',\n' ORDER BY a.attnum)
+        FROM pg_attribute a LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+        WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped), ''),
+    CASE WHEN c.relkind = 'p' THEN ' PARTITION BY ' || pg_get_partkeydef(c.oid) ELSE '' END)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + ` ORDER BY n.nspname, c.relname`,
+	// ограничения таблиц: первичные и уникальные ключи, внешние ключи, CHECK, EXCLUDE
+	`SELECT format('ALTER TABLE %I.%I ADD CONSTRAINT %I %s;', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE con.contype IN ('p', 'u', 'f', 'c', 'x') AND NOT c.relispartition AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + ` ORDER BY n.nspname, c.relname, con.conname`,
+	// индексы, не созданные ограничениями
+	`SELECT pg_get_indexdef(i.indexrelid) || ';'
+FROM pg_index i JOIN pg_class ic ON ic.oid = i.indexrelid JOIN pg_class c ON c.oid = i.indrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE NOT c.relispartition AND c.relkind IN ('r', 'p', 'm') AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + `
+AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = i.indexrelid AND con.contype IN ('p', 'u', 'x'))
+ORDER BY n.nspname, c.relname, ic.relname`,
+	// представления и материализованные представления
+	`SELECT format(E'CREATE %sVIEW %I.%I AS\n%s;', CASE WHEN c.relkind = 'm' THEN 'MATERIALIZED ' ELSE '' END,
+    n.nspname, c.relname, rtrim(trim(pg_get_viewdef(c.oid, true)), ';'))
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('v', 'm') AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + ` ORDER BY n.nspname, c.relname`,
+	// функции и процедуры
+	`SELECT rtrim(pg_get_functiondef(p.oid), E'\n') || ';'
+FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.prokind IN ('f', 'p') AND ` + userNamespace + ` AND ` + notExtension("pg_proc", "p.oid") + ` ORDER BY n.nspname, p.proname, pg_get_function_identity_arguments(p.oid)`,
+	// права на таблицы, представления и последовательности, кроме прав владельца
+	`SELECT format('GRANT %s ON %s %I.%I TO %s;', string_agg(a.privilege_type, ', ' ORDER BY a.privilege_type),
+    CASE WHEN c.relkind = 'S' THEN 'SEQUENCE' ELSE 'TABLE' END, n.nspname, c.relname,
+    CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE quote_ident(pg_get_userbyid(a.grantee)) END)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace, LATERAL aclexplode(c.relacl) a
+WHERE c.relacl IS NOT NULL AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f') AND a.grantee <> c.relowner
+AND NOT c.relispartition AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + `
+GROUP BY n.nspname, c.relname, c.relkind, a.grantee ORDER BY n.nspname, c.relname, pg_get_userbyid(a.grantee)`,
+	// триггеры
+	`SELECT pg_get_triggerdef(t.oid, true) || ';'
+FROM pg_trigger t JOIN pg_class c ON c.oid = t.tgrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE NOT t.tgisinternal AND NOT c.relispartition AND ` + userNamespace + ` AND ` + notMigrator + `
+AND ` + notExtension("pg_class", "c.oid") + ` ORDER BY n.nspname, c.relname, t.tgname`,
+}
+
+// SchemaSnapshot describes the user schemas as normalised DDL built from pg_catalog,
+// without pg_dump. The migrator's own tables and objects of extensions are skipped.
+func (d *DB) SchemaSnapshot(ctx context.Context) ([]string, error) {
+	skip := []string{d.SchemaTable, d.HistoryTable()}
+	var out []string
+	for _, q := range snapshotQueries {
+		var args []any
+		if strings.Contains(q, "$1") {
+			args = append(args, skip)
+		}
+		rows, err := d.Pool.Query(ctx, q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var stmt string
+			if err := rows.Scan(&stmt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			// пустые строки внутри выражения разделяли бы блоки снимка
+			for strings.Contains(stmt, "\n\n") {
+				stmt = strings.ReplaceAll(stmt, "\n\n", "\n")
+			}
+			out = append(out, stmt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ScratchDatabase creates an empty database on the same server and connects to it
+// with the same credentials. drop closes the connection and removes the database;
+// it ignores cancellation of ctx so the database is not left behind on SIGINT.
+// The connecting role needs the CREATEDB privilege.
+func (d *DB) ScratchDatabase(ctx context.Context, name string) (migrator.Driver, func(), error) {
+	ident := pgx.Identifier{name}.Sanitize()
+	if _, err := d.Pool.Exec(ctx, "CREATE DATABASE "+ident); err != nil {
+		return nil, nil, err
+	}
+	dropDB := func() {
+		bg, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = d.Pool.Exec(bg, "DROP DATABASE IF EXISTS "+ident)
+	}
+	cfg := d.Pool.Config()
+	cfg.ConnConfig.Database = name
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		dropDB()
+		return nil, nil, err
+	}
+	scratch := &DB{Pool: pool, SchemaTable: d.SchemaTable, LockKey: d.LockKey}
+	if err := scratch.EnsureTables(ctx); err != nil {
+		pool.Close()
+		dropDB()
+		return nil, nil, err
+	}
+	return scratch, func() {
+		pool.Close()
+		dropDB()
+	}, nil
+}
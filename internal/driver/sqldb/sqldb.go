@@ -0,0 +1,250 @@
+// Package sqldb implements the migrator driver on top of database/sql.
+// Database-specific DDL, upserts and locking are supplied by a Dialect.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"migrator/internal/migrator"
+)
+
+// Dialect describes the SQL differences between databases served by DB.
+// Queries use `?` placeholders.
+type Dialect interface {
+	// CreateTables returns the statements creating the schema and history tables.
+	CreateTables(schemaTable, historyTable string) []string
+	// Upsert returns an INSERT of all schema table columns (version, name, checksum,
+	// status, applied_at, updated_at, execution_ms, error_text) that updates the
+	// row when the version already exists.
+	Upsert(schemaTable string) string
+	// TryLock tries to acquire the migrator lock on the dedicated connection without waiting.
+	TryLock(ctx context.Context, conn *sql.Conn, key int64) (bool, error)
+	// Unlock releases the lock taken by TryLock.
+	Unlock(ctx context.Context, conn *sql.Conn, key int64) error
+	// LockHolders reports the sessions holding the lock; nil if unknown.
+	LockHolders(ctx context.Context, conn *sql.Conn, key int64) ([]migrator.LockHolder, error)
+	// TransactionalDDL reports whether DDL is rolled back together with the transaction.
+	TransactionalDDL() bool
+	// TableExistsQuery returns a query counting tables named by its single parameter.
+	TableExistsQuery() string
+	// Syntax selects the rules for splitting migrations into statements.
+	Syntax() migrator.Syntax
+}
+
+// DB is a migrator driver over a database/sql connection pool.
+type DB struct {
+	SQL         *sql.DB
+	SchemaTable string
+	LockKey     int64
+	Dialect     Dialect
+	// Lock configures waiting for the migrator lock.
+	Lock migrator.LockOptions
+}
+
+var (
+	_ migrator.Driver         = (*DB)(nil)
+	_ migrator.TableChecker   = (*DB)(nil)
+	_ migrator.SyntaxReporter = (*DB)(nil)
+	_ migrator.SyntaxReporter = (*conn)(nil)
+)
+
+// HistoryTable returns the name of the audit table kept next to the schema table.
+func (d *DB) HistoryTable() string { return d.SchemaTable + "_history" }
+
+// Close closes the connection pool.
+func (d *DB) Close() { _ = d.SQL.Close() }
+
+// EnsureTables creates the schema and history tables if they do not exist.
+func (d *DB) EnsureTables(ctx context.Context) error {
+	for _, stmt := range d.Dialect.CreateTables(d.SchemaTable, d.HistoryTable()) {
+		if _, err := d.SQL.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithAdvisoryLock executes fn while holding the dialect's migrator lock.
+// The lock is polled with TryLock, so the wait honours d.Lock.Timeout.
+func (d *DB) WithAdvisoryLock(ctx context.Context, fn func(context.Context) error) error {
+	// блокировка привязана к сессии, поэтому держим отдельное соединение
+	conn, err := d.SQL.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	try := func(ctx context.Context) (bool, error) { return d.Dialect.TryLock(ctx, conn, d.LockKey) }
+	holders := func(ctx context.Context) ([]migrator.LockHolder, error) {
+		return d.Dialect.LockHolders(ctx, conn, d.LockKey)
+	}
+	if err := migrator.AcquireLock(ctx, d.Lock, try, holders); err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Dialect.Unlock(context.WithoutCancel(ctx), conn, d.LockKey)
+	}()
+	return fn(ctx)
+}
+
+// Syntax returns the statement splitting rules of the dialect.
+func (d *DB) Syntax() migrator.Syntax { return d.Dialect.Syntax() }
+
+// SchemaTableExists reports whether the schema table has been created.
+func (d *DB) SchemaTableExists(ctx context.Context) (bool, error) {
+	var n int
+	err := d.SQL.QueryRowContext(ctx, d.Dialect.TableExistsQuery(), d.SchemaTable).Scan(&n)
+	return n > 0, err
+}
+
+// TransactionalDDL reports whether a failed migration transaction leaves no partial DDL.
+func (d *DB) TransactionalDDL() bool { return d.Dialect.TransactionalDDL() }
+
+// LoadHistory returns history entries matching f, newest first.
+func (d *DB) LoadHistory(ctx context.Context, f migrator.HistoryFilter) ([]migrator.HistoryEntry, error) {
+	q, args := migrator.HistoryQuery(d.HistoryTable(), f, func(int) string { return "?" })
+	rows, err := d.SQL.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []migrator.HistoryEntry
+	for rows.Next() {
+		e, err := migrator.ScanHistory(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// LoadRecords returns all rows of the schema table ordered by version.
+func (d *DB) LoadRecords(ctx context.Context) ([]migrator.Record, error) {
+	rows, err := d.SQL.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s ORDER BY version`, recordColumns, d.SchemaTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []migrator.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// InTx runs fn in a transaction, committing on success and rolling back on error.
+func (d *DB) InTx(ctx context.Context, fn func(migrator.Conn) error) error {
+	tx, err := d.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&conn{db: d, q: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// WithConn runs fn on a single pooled connection outside a transaction.
+func (d *DB) WithConn(ctx context.Context, fn func(migrator.Conn) error) error {
+	c, err := d.SQL.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return fn(&conn{db: d, q: c})
+}
+
+const recordColumns = "version, name, checksum, status, applied_at, updated_at, COALESCE(execution_ms, 0), error_text"
+
+// scanner — общее подмножество *sql.Row и *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(s scanner) (migrator.Record, error) {
+	var (
+		rec       migrator.Record
+		status    string
+		appliedAt sql.NullTime
+		updatedAt sql.NullTime
+		errorText sql.NullString
+	)
+	if err := s.Scan(&rec.Version, &rec.Name, &rec.Checksum, &status, &appliedAt, &updatedAt, &rec.ExecutionMs, &errorText); err != nil {
+		return migrator.Record{}, err
+	}
+	rec.Status = migrator.MigrationStatus(status)
+	if appliedAt.Valid {
+		t := appliedAt.Time
+		rec.AppliedAt = &t
+	}
+	rec.UpdatedAt = updatedAt.Time
+	if errorText.Valid {
+		rec.ErrorText = &errorText.String
+	}
+	return rec, nil
+}
+
+// querier — общее подмножество *sql.Tx и *sql.Conn.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn реализует migrator.Conn поверх транзакции или отдельного соединения.
+type conn struct {
+	db *DB
+	q  querier
+}
+
+func (c *conn) Syntax() migrator.Syntax { return c.db.Dialect.Syntax() }
+
+func (c *conn) Exec(ctx context.Context, sql string) error {
+	_, err := c.q.ExecContext(ctx, sql)
+	return err
+}
+
+func (c *conn) GetRecord(ctx context.Context, version int64) (migrator.Record, bool, error) {
+	row := c.q.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE version=?`, recordColumns, c.db.SchemaTable), version)
+	rec, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return migrator.Record{}, false, nil
+	}
+	if err != nil {
+		return migrator.Record{}, false, err
+	}
+	return rec, true, nil
+}
+
+func (c *conn) SaveRecord(ctx context.Context, rec migrator.Record) error {
+	var appliedAt, errorText any
+	if rec.AppliedAt != nil {
+		appliedAt = rec.AppliedAt.UTC()
+	}
+	if rec.ErrorText != nil {
+		errorText = *rec.ErrorText
+	}
+	_, err := c.q.ExecContext(ctx, c.db.Dialect.Upsert(c.db.SchemaTable),
+		rec.Version, rec.Name, rec.Checksum, string(rec.Status), appliedAt, time.Now().UTC(), rec.ExecutionMs, errorText)
+	return err
+}
+
+func (c *conn) DeleteRecord(ctx context.Context, version int64) error {
+	_, err := c.q.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=?", c.db.SchemaTable), version)
+	return err
+}
+
+func (c *conn) AddHistory(ctx context.Context, e migrator.HistoryEntry) error {
+	_, err := c.q.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s(version,action,direction,checksum,duration_ms,error_text,details,hostname,os_user,tool_version,run_id,created_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?)`, c.db.HistoryTable()),
+		e.Version, e.Action, e.Direction, e.Checksum, e.Duration.Milliseconds(), e.Error, e.Details, e.Hostname, e.OSUser, e.ToolVersion, e.RunID, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile берет flock на файл name без ожидания; блокировка снимается при закрытии
+// файла или завершении процесса.
+func lockFile(name string) (*os.File, bool, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	// владелец мог удалить файл между нашими open и flock: блокировка на
+	// удаленном файле никого не исключает, поэтому считаем ее занятой
+	held, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, false, err
+	}
+	if cur, err := os.Stat(name); err != nil || !os.SameFile(held, cur) {
+		_ = f.Close()
+		return nil, false, nil
+	}
+	return f, true, nil
+}
+
+// unlockFile удаляет файл, пока блокировка еще удерживается, и только потом ее снимает.
+func unlockFile(f *os.File) error {
+	err := os.Remove(f.Name())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+// Package sqlite provides a pure-Go SQLite driver for migrations.
+// It is intended for local development and hermetic tests.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	_ "modernc.org/sqlite" // регистрирует драйвер database/sql "sqlite"
+
+	"migrator/internal/driver/sqldb"
+	"migrator/internal/migrator"
+)
+
+// Scheme is the DSN prefix handled by this driver.
+const Scheme = "sqlite://"
+
+// Connect opens a SQLite database file and initializes service tables.
+// The DSN has the form sqlite://path/to/file.db (relative) or sqlite:///abs/path.db;
+// query parameters are passed to the SQLite driver unchanged.
+func Connect(ctx context.Context, dsn, schemaTable string, lockKey int64) (*sqldb.DB, error) {
+	db, err := Open(dsn, schemaTable, lockKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.EnsureTables(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open opens the database file without touching the service tables.
+func Open(dsn, schemaTable string, lockKey int64) (*sqldb.DB, error) {
+	path, dsnParams, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	path, dsnParams = sharedMemory(path, dsnParams)
+	conn, err := sql.Open("sqlite", path+"?"+dsnParams)
+	if err != nil {
+		return nil, err
+	}
+	return &sqldb.DB{SQL: conn, SchemaTable: schemaTable, LockKey: lockKey, Dialect: Dialect{Path: path, Params: dsnParams}}, nil
+}
+
+// memoryDBs нумерует базы в памяти, открытые через Open.
+var memoryDBs atomic.Int64
+
+// sharedMemory делает базу в памяти общей для соединений пула: без cache=shared
+// каждое соединение database/sql открывает свою пустую базу, и миграции,
+// выполняемые рядом с соединением блокировки, не видят служебных таблиц.
+// Безымянная :memory: получает уникальное имя, чтобы два Open не делили одну базу.
+// База живет, пока открыто хотя бы одно соединение пула.
+func sharedMemory(path, params string) (string, string) {
+	if !inMemory(path, params) {
+		return path, params
+	}
+	if q, err := url.ParseQuery(params); err == nil && q.Get("cache") == "shared" {
+		return path, params
+	}
+	if strings.Contains(path, ":memory:") {
+		path = fmt.Sprintf("file:gomigrator-memory-%d", memoryDBs.Add(1))
+		params += "&mode=memory"
+	}
+	return path, params + "&cache=shared"
+}
+
+// ParseDSN splits a sqlite:// DSN into the database file path and driver parameters.
+// A busy timeout is added unless the DSN sets its own pragmas.
+func ParseDSN(dsn string) (path, params string, err error) {
+	if !strings.HasPrefix(dsn, Scheme) {
+		return "", "", fmt.Errorf("sqlite dsn must start with %s", Scheme)
+	}
+	path, params, _ = strings.Cut(strings.TrimPrefix(dsn, Scheme), "?")
+	if path == "" {
+		return "", "", fmt.Errorf("sqlite dsn: missing database path")
+	}
+	if !strings.Contains(params, "_pragma=") {
+		// без busy_timeout параллельные соединения пула сразу получают SQLITE_BUSY
+		if params != "" {
+			params += "&"
+		}
+		params += "_pragma=busy_timeout(5000)"
+	}
+	return path, params, nil
+}
+
+// Dialect implements sqldb.Dialect for SQLite.
+type Dialect struct {
+	// Path is the database file; the lock file is created next to it. If empty,
+	// the path is read from PRAGMA database_list; an in-memory database is
+	// locked only within the process.
+	Path string
+	// Params are the DSN query parameters; mode=memory marks an in-memory
+	// database opened through a file: URI.
+	Params string
+}
+
+// Syntax returns the SQLite splitting rules: doubled quotes, no dollar quoting.
+func (Dialect) Syntax() migrator.Syntax { return migrator.SyntaxSQLite }
+
+// TableExistsQuery looks the table up in sqlite_master.
+func (Dialect) TableExistsQuery() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+// CreateTables returns DDL for the schema and history tables.
+func (Dialect) CreateTables(schemaTable, historyTable string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    version         INTEGER NOT NULL UNIQUE,
+    name            TEXT NOT NULL,
+    checksum        TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    applied_at      DATETIME,
+    updated_at      DATETIME NOT NULL,
+    execution_ms    INTEGER DEFAULT 0,
+    error_text      TEXT
+)`, schemaTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    version         INTEGER NOT NULL,
+    action          TEXT NOT NULL,
+    direction       TEXT,
+    checksum        TEXT,
+    duration_ms     INTEGER,
+    error_text      TEXT,
+    details         TEXT,
+    hostname        TEXT,
+    os_user         TEXT,
+    tool_version    TEXT,
+    run_id          TEXT,
+    created_at      DATETIME NOT NULL
+)`, historyTable),
+	}
+}
+
+// Upsert returns an INSERT ... ON CONFLICT DO UPDATE for the schema table.
+func (Dialect) Upsert(schemaTable string) string {
+	return fmt.Sprintf(`INSERT INTO %s(version,name,checksum,status,applied_at,updated_at,execution_ms,error_text)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(version) DO UPDATE SET name=excluded.name, checksum=excluded.checksum, status=excluded.status,
+applied_at=excluded.applied_at, updated_at=excluded.updated_at, execution_ms=excluded.execution_ms, error_text=excluded.error_text`, schemaTable)
+}
+
+// SQLite не имеет advisory-блокировок: блокировку заменяет файловая блокировка
+// рядом с файлом базы, она видна всем процессам и снимается ОС при их завершении.
+// BEGIN IMMEDIATE не подходит: он запретил бы запись самим миграциям из пула.
+// У базы в памяти файла нет, и ее видит только этот процесс, поэтому для нее
+// хватает блокировки внутри процесса (в locks хранится nil).
+var (
+	locksMu sync.Mutex
+	locks   = map[string]*os.File{}
+)
+
+// LockFile returns the path of the file locked by the migrator for key, or ""
+// for an in-memory database or an unknown path.
+func (d Dialect) LockFile(key int64) string {
+	if inMemory(d.Path, d.Params) {
+		return ""
+	}
+	name := d.Path
+	if abs, err := filepath.Abs(d.Path); err == nil {
+		name = abs
+	}
+	return fmt.Sprintf("%s.%d.lock", name, key)
+}
+
+// inMemory сообщает, что у базы нет файла, на котором можно взять блокировку.
+// Режим mode=memory задается в параметрах DSN, которые ParseDSN отделяет от пути.
+func inMemory(path, params string) bool {
+	if path == "" || strings.Contains(path, ":memory:") {
+		return true
+	}
+	q, err := url.ParseQuery(params)
+	if err != nil {
+		return strings.Contains(params, "mode=memory")
+	}
+	return q.Get("mode") == "memory"
+}
+
+// resolve возвращает диалект с путем к файлу базы: если путь не задан (пул
+// передан через WithSQLDB), он читается из PRAGMA database_list соединения.
+func (d Dialect) resolve(ctx context.Context, conn *sql.Conn) (Dialect, error) {
+	if d.Path != "" || conn == nil {
+		return d, nil
+	}
+	// для базы в памяти и временной базы file пустой
+	err := conn.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&d.Path)
+	return d, err
+}
+
+// lockName — ключ блокировки внутри процесса: файл блокировки или, для базы
+// в памяти, просто ключ.
+func (d Dialect) lockName(key int64) string {
+	if name := d.LockFile(key); name != "" {
+		return name
+	}
+	return fmt.Sprintf("memory#%d", key)
+}
+
+// TryLock takes the file lock of the database if no other process or connection holds it.
+func (d Dialect) TryLock(ctx context.Context, conn *sql.Conn, key int64) (bool, error) {
+	d, err := d.resolve(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	name := d.lockName(key)
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if _, held := locks[name]; held {
+		return false, nil
+	}
+	var f *os.File
+	if file := d.LockFile(key); file != "" {
+		var ok bool
+		if f, ok, err = lockFile(file); err != nil || !ok {
+			return false, err
+		}
+		// PID владельца читает LockHolders у ожидающих процессов
+		if err := f.Truncate(0); err == nil {
+			_, _ = fmt.Fprintf(f, "%d\n", os.Getpid())
+		}
+	}
+	locks[name] = f
+	return true, nil
+}
+
+// Unlock releases the lock taken by TryLock and removes the lock file.
+func (d Dialect) Unlock(ctx context.Context, conn *sql.Conn, key int64) error {
+	d, err := d.resolve(ctx, conn)
+	if err != nil {
+		return err
+	}
+	name := d.lockName(key)
+	locksMu.Lock()
+	f, ok := locks[name]
+	delete(locks, name)
+	locksMu.Unlock()
+	if !ok || f == nil {
+		return nil
+	}
+	return unlockFile(f)
+}
+
+// TransactionalDDL returns true: SQLite rolls back DDL with the transaction.
+func (Dialect) TransactionalDDL() bool { return true }
+
+// LockHolders reports the process that wrote its PID into the lock file.
+func (d Dialect) LockHolders(ctx context.Context, conn *sql.Conn, key int64) ([]migrator.LockHolder, error) {
+	d, err := d.resolve(ctx, conn)
+	if err != nil || d.LockFile(key) == "" {
+		return nil, err
+	}
+	data, err := os.ReadFile(d.LockFile(key))
+	if err != nil {
+		return nil, nil
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	return []migrator.LockHolder{{PID: pid, State: "holds " + d.LockFile(key)}}, nil
+}
@@ -0,0 +1,541 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"migrator/internal/driver/sqldb"
+	"migrator/internal/migrator"
+)
+
+func connect(t *testing.T) *sqldb.DB {
+	t.Helper()
+	db, err := Connect(context.Background(), Scheme+filepath.Join(t.TempDir(), "test.db"), "schema_migrations", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func step(v int64, name, up, down string) migrator.Step {
+	return migrator.Step{Version: v, Name: name, Kind: migrator.KindSQL, UpSQL: up, DownSQL: down, Checksum: name}
+}
+
+func tableExists(t *testing.T, db *sqldb.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.SQL.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func dbVersion(t *testing.T, r *migrator.Runner) int64 {
+	t.Helper()
+	v, err := r.DBVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseDSN(t *testing.T) {
+	path, params, err := ParseDSN("sqlite://data/dev.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "data/dev.db" || params != "_pragma=busy_timeout(5000)" {
+		t.Errorf("unexpected parse result: %q %q", path, params)
+	}
+	path, params, err = ParseDSN("sqlite:///tmp/x.db?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/tmp/x.db" || params != "_pragma=foreign_keys(1)" {
+		t.Errorf("unexpected parse result: %q %q", path, params)
+	}
+	for _, dsn := range []string{"sqlite://", "postgres://x"} {
+		if _, _, err := ParseDSN(dsn); err == nil {
+			t.Errorf("expected error for %q", dsn)
+		}
+	}
+}
+
+func TestSplitStatements_SQLite(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "no dollar quoting",
+			sql:  "INSERT INTO t VALUES ('a', $x$);\nSELECT 1;\nSELECT '$x$';",
+			want: []string{"INSERT INTO t VALUES ('a', $x$);", "SELECT 1;", "SELECT '$x$';"},
+		},
+		{
+			name: "backslash is not an escape",
+			sql:  "SELECT E'a\\';\nSELECT 'it''s; ok';",
+			want: []string{"SELECT E'a\\';", "SELECT 'it''s; ok';"},
+		},
+		{
+			name: "backtick identifier",
+			sql:  "CREATE TABLE `a;b` (id INTEGER);\nSELECT 1;",
+			want: []string{"CREATE TABLE `a;b` (id INTEGER);", "SELECT 1;"},
+		},
+		{
+			name: "block comments do not nest",
+			sql:  "/* a /* b */ SELECT 1;\nSELECT 2;",
+			want: []string{"/* a /* b */ SELECT 1;", "SELECT 2;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmts, err := migrator.SplitStatementsSyntax(tt.sql, Dialect{}.Syntax())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range stmts {
+				got = append(got, s.SQL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunner_UpDownRedo(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	steps := []migrator.Step{
+		step(1, "foo", "CREATE TABLE foo(id INTEGER PRIMARY KEY);", "DROP TABLE foo;"),
+		step(2, "bar", "CREATE TABLE bar(id INTEGER); INSERT INTO bar VALUES (1);", "DROP TABLE bar;"),
+	}
+	if err := r.Up(ctx, steps); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if !tableExists(t, db, "foo") || !tableExists(t, db, "bar") {
+		t.Fatal("expected foo and bar to exist")
+	}
+	if v := dbVersion(t, r); v != 2 {
+		t.Fatalf("expected version 2, got %d", v)
+	}
+	if err := r.Redo(ctx, steps); err != nil {
+		t.Fatalf("redo: %v", err)
+	}
+	if err := r.Down(ctx, steps); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if tableExists(t, db, "bar") {
+		t.Fatal("expected bar to be dropped")
+	}
+	if v := dbVersion(t, r); v != 1 {
+		t.Fatalf("expected version 1, got %d", v)
+	}
+	if err := r.DownTo(ctx, steps, 0); err != nil {
+		t.Fatalf("down to 0: %v", err)
+	}
+	if v := dbVersion(t, r); v != 0 {
+		t.Fatalf("expected version 0, got %d", v)
+	}
+}
+
+func TestRunner_FailedMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	steps := []migrator.Step{
+		step(1, "ok", "CREATE TABLE ok(id INTEGER);", "DROP TABLE ok;"),
+		step(2, "broken", "CREATE TABLE half(id INTEGER);\nINSERT INTO missing VALUES (1);", "DROP TABLE half;"),
+	}
+	err := r.Up(ctx, steps)
+	var stmtErr *migrator.StatementError
+	if !errors.As(err, &stmtErr) || stmtErr.Index != 2 || stmtErr.Line != 2 {
+		t.Fatalf("expected statement 2 (line 2) error, got %v", err)
+	}
+	if tableExists(t, db, "half") {
+		t.Fatal("transaction of the failed migration must be rolled back")
+	}
+	if v := dbVersion(t, r); v != 1 {
+		t.Fatalf("expected version 1, got %d", v)
+	}
+	rows, err := r.Status(ctx, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[1].Status != migrator.StateFailed || !strings.Contains(rows[1].ErrorText, "missing") {
+		t.Fatalf("expected failed row with error text, got %+v", rows[1])
+	}
+	// транзакция откатилась: исправленная миграция применяется без repair
+	steps[1] = step(2, "broken", "CREATE TABLE half(id INTEGER);", "DROP TABLE half;")
+	if err := r.Up(ctx, steps); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if v := dbVersion(t, r); v != 2 {
+		t.Fatalf("expected version 2, got %d", v)
+	}
+}
+
+func TestRunner_TimeoutDirectiveNeedsPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	// умолчания из конфигурации на SQLite игнорируются
+	r.StatementTimeout = time.Minute
+	if err := r.Up(ctx, []migrator.Step{step(1, "a", "CREATE TABLE a(id INTEGER);", "")}); err != nil {
+		t.Fatalf("default timeouts must be ignored: %v", err)
+	}
+	s := step(2, "b", "CREATE TABLE b(id INTEGER);", "")
+	s.LockTimeout = 5 * time.Second
+	if err := r.Up(ctx, []migrator.Step{s}); err == nil || !strings.Contains(err.Error(), "require the postgres driver") {
+		t.Fatalf("expected unsupported directive error, got %v", err)
+	}
+}
+
+func TestRunner_RetryRecordsAttempts(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	r.Retry = migrator.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+		return strings.Contains(err.Error(), "missing")
+	}}
+	steps := []migrator.Step{step(1, "busy", "INSERT INTO missing VALUES (1);", "")}
+	if err := r.Up(ctx, steps); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected the last attempt's error, got %v", err)
+	}
+	// одна запись на попытку: retry для повторенных, failed для последней
+	entries, err := r.History(ctx, migrator.HistoryFilter{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Action)
+		if !strings.Contains(e.Error, "missing") {
+			t.Fatalf("entry must carry the attempt's error: %+v", e)
+		}
+	}
+	if strings.Join(got, ",") != "failed,retry,retry" || !strings.HasPrefix(entries[2].Details, "attempt 1/3 failed, retrying in ") {
+		t.Fatalf("unexpected retry history %v: %+v", got, entries)
+	}
+	var n int
+	// нетранзиентные ошибки не повторяются
+	r.Retry.Retryable = nil
+	if err := r.Up(ctx, steps); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := db.SQL.QueryRow("SELECT count(*) FROM schema_migrations_history WHERE action='retry'").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("expected no new retry entries, got %d (%v)", n, err)
+	}
+}
+
+func TestRunner_History(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	steps := []migrator.Step{
+		step(1, "foo", "CREATE TABLE foo(id INTEGER);", "DROP TABLE foo;"),
+		step(2, "broken", "INSERT INTO missing VALUES (1);", ""),
+	}
+	if err := r.Up(ctx, steps); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := r.Down(ctx, steps); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	entries, err := r.History(ctx, migrator.HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%d %s %s", e.Version, e.Action, e.Direction))
+		if e.RunID != r.Run.ID || e.Hostname != r.Run.Hostname || e.CreatedAt.IsZero() {
+			t.Fatalf("entry must carry run info: %+v", e)
+		}
+	}
+	if want := "1 rolled-back down,2 failed up,1 applied up"; strings.Join(got, ",") != want {
+		t.Fatalf("history = %v; want newest first %s", got, want)
+	}
+	if !strings.Contains(entries[1].Error, "missing") || entries[2].Checksum != "foo" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	only, err := r.History(ctx, migrator.HistoryFilter{Version: 1, Limit: 1})
+	if err != nil || len(only) != 1 || only[0].Action != "rolled-back" {
+		t.Fatalf("unexpected filtered history: %+v (%v)", only, err)
+	}
+}
+
+func TestEnsureTables_CreatesFullHistory(t *testing.T) {
+	db := connect(t)
+	rows, err := db.SQL.Query("SELECT name FROM pragma_table_info('schema_migrations_history')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, name)
+	}
+	want := "id,version,action,direction,checksum,duration_ms,error_text,details,hostname,os_user,tool_version,run_id,created_at"
+	if got := strings.Join(cols, ","); got != want {
+		t.Fatalf("history columns = %s; want %s", got, want)
+	}
+	if err := db.EnsureTables(context.Background()); err != nil {
+		t.Fatalf("second EnsureTables: %v", err)
+	}
+}
+
+func TestRunner_SchemaFileNeedsPostgres(t *testing.T) {
+	r := migrator.NewRunner(connect(t))
+	r.SchemaFile = filepath.Join(t.TempDir(), "schema.sql")
+	err := r.Up(context.Background(), []migrator.Step{step(1, "a", "CREATE TABLE a(id INTEGER);", "")})
+	if err == nil || !strings.Contains(err.Error(), "require the postgres driver") {
+		t.Fatalf("expected unsupported snapshot error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "migrations were applied") {
+		t.Fatalf("error must say the migrations were applied: %v", err)
+	}
+	if v := dbVersion(t, r); v != 1 {
+		t.Fatalf("expected version 1 despite the snapshot failure, got %d", v)
+	}
+}
+
+func TestRunner_NoTransactionFailureIsStuck(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	s := step(1, "notx", "CREATE TABLE a(id INTEGER);\nINSERT INTO missing VALUES (1);", "")
+	s.NoTransaction = true
+	steps := []migrator.Step{s}
+	if err := r.Up(ctx, steps); err == nil {
+		t.Fatal("expected error")
+	}
+	rows, err := r.Status(ctx, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Status != migrator.StateFailed || !strings.Contains(rows[0].ErrorText, "missing") {
+		t.Fatalf("expected failed row with error text, got %+v", rows)
+	}
+	var stuck *migrator.StuckError
+	if err := r.Up(ctx, steps); !errors.As(err, &stuck) {
+		t.Fatalf("expected StuckError, got %v", err)
+	}
+	removed, err := r.Repair(ctx)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("repair: %v %+v", err, removed)
+	}
+}
+
+func TestRunner_OutOfOrderAndDrift(t *testing.T) {
+	ctx := context.Background()
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	first := []migrator.Step{step(2, "two", "CREATE TABLE two(id INTEGER);", "DROP TABLE two;")}
+	if err := r.Up(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	all := append([]migrator.Step{step(1, "one", "CREATE TABLE one(id INTEGER);", "DROP TABLE one;")}, first...)
+	var ooo *migrator.OutOfOrderError
+	if err := r.Up(ctx, all); !errors.As(err, &ooo) {
+		t.Fatalf("expected OutOfOrderError, got %v", err)
+	}
+	r.AllowOutOfOrder = true
+	if err := r.Up(ctx, all); err != nil {
+		t.Fatalf("up with allow_out_of_order: %v", err)
+	}
+
+	all[1].Checksum = "edited"
+	var drift *migrator.DriftError
+	if err := r.Up(ctx, all); !errors.As(err, &drift) {
+		t.Fatalf("expected DriftError, got %v", err)
+	}
+	if _, err := r.AcceptChecksums(ctx, all); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := r.History(ctx, migrator.HistoryFilter{Version: 2, Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].Action != "accept-checksum" || entries[0].Checksum != "edited" ||
+		entries[0].Details != "checksum two replaced with edited" {
+		t.Fatalf("accept must be recorded in history: %+v (%v)", entries, err)
+	}
+	if err := r.Up(ctx, all); err != nil {
+		t.Fatalf("up after accept: %v", err)
+	}
+}
+
+func TestRunner_GoMigrationNeedsPostgres(t *testing.T) {
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	s := migrator.GoStep{Version: 1, Name: "go", Up: func(context.Context, pgx.Tx) error { return nil }}.Step()
+	if err := r.Up(context.Background(), []migrator.Step{s}); err == nil || !strings.Contains(err.Error(), "postgres") {
+		t.Fatalf("expected postgres-only error, got %v", err)
+	}
+}
+
+func TestRunner_StopBeforeNextMigration(t *testing.T) {
+	db := connect(t)
+	r := migrator.NewRunner(db)
+	steps := []migrator.Step{
+		step(1, "one", "CREATE TABLE one(id INTEGER);", "DROP TABLE one;"),
+		step(2, "two", "CREATE TABLE two(id INTEGER);", "DROP TABLE two;"),
+	}
+	stop, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := r.Up(migrator.WithStop(context.Background(), stop), steps)
+	if !errors.Is(err, migrator.ErrInterrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected interrupted error, got %v", err)
+	}
+	if v := dbVersion(t, r); v != 0 {
+		t.Fatalf("nothing must be applied after stop, got version %d", v)
+	}
+	if err := r.Up(context.Background(), steps); err != nil {
+		t.Fatalf("up after stop: %v", err)
+	}
+}
+
+func TestWithAdvisoryLock_Timeout(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lock.db")
+	first, err := Connect(ctx, Scheme+path, "schema_migrations", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := Connect(ctx, Scheme+path, "schema_migrations", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.Lock = migrator.LockOptions{Timeout: 50 * time.Millisecond}
+
+	err = first.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		return migrator.NewRunner(second).Up(ctx, nil)
+	})
+	var lte *migrator.LockTimeoutError
+	if !errors.As(err, &lte) {
+		t.Fatalf("expected LockTimeoutError, got %v", err)
+	}
+	if err := migrator.NewRunner(second).Up(ctx, nil); err != nil {
+		t.Fatalf("lock must be free after the holder returns: %v", err)
+	}
+}
+
+func TestTryLock_LockFile(t *testing.T) {
+	ctx := context.Background()
+	d := Dialect{Path: filepath.Join(t.TempDir(), "lock.db")}
+	// блокировку другого процесса имитирует отдельный дескриптор файла
+	f, ok, err := lockFile(d.LockFile(7))
+	if err != nil || !ok {
+		t.Fatalf("lock file: %v %v", ok, err)
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", 4242); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.TryLock(ctx, nil, 7); err != nil || ok {
+		t.Fatalf("lock held by another descriptor must be busy: %v %v", ok, err)
+	}
+	holders, err := d.LockHolders(ctx, nil, 7)
+	if err != nil || len(holders) != 1 || holders[0].PID != 4242 {
+		t.Fatalf("unexpected holders: %v %v", holders, err)
+	}
+	if err := unlockFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.TryLock(ctx, nil, 7); err != nil || !ok {
+		t.Fatalf("free lock must be taken: %v %v", ok, err)
+	}
+	if err := d.Unlock(ctx, nil, 7); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryLock_ResolvesPathFromConnection(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var d Dialect // путь неизвестен, как у пула из WithSQLDB
+	if ok, err := d.TryLock(ctx, conn, 3); err != nil || !ok {
+		t.Fatalf("lock: %v %v", ok, err)
+	}
+	lockPath := Dialect{Path: path}.LockFile(3)
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("lock file must be created next to the database: %v", err)
+	}
+	if ok, err := (Dialect{Path: path}).TryLock(ctx, nil, 3); err != nil || ok {
+		t.Fatalf("the same file must be locked through its path too: %v %v", ok, err)
+	}
+	if err := d.Unlock(ctx, conn, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("lock file must be removed on unlock: %v", err)
+	}
+}
+
+func TestTryLock_InMemory(t *testing.T) {
+	ctx := context.Background()
+	d := Dialect{Path: ":memory:"}
+	if d.LockFile(4) != "" {
+		t.Fatalf("in-memory database must not get a lock file, got %q", d.LockFile(4))
+	}
+	if ok, err := d.TryLock(ctx, nil, 4); err != nil || !ok {
+		t.Fatalf("lock: %v %v", ok, err)
+	}
+	if ok, _ := d.TryLock(ctx, nil, 4); ok {
+		t.Fatal("second lock in the same process must be busy")
+	}
+	if err := d.Unlock(ctx, nil, 4); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.TryLock(ctx, nil, 4); err != nil || !ok {
+		t.Fatalf("lock after unlock: %v %v", ok, err)
+	}
+	_ = d.Unlock(ctx, nil, 4)
+}
+
+func TestConnect_MemoryModeURI(t *testing.T) {
+	ctx := context.Background()
+	t.Chdir(t.TempDir())
+	db, err := Connect(ctx, Scheme+"file:x?mode=memory&cache=shared", "schema_migrations", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if f := db.Dialect.(Dialect).LockFile(5); f != "" {
+		t.Fatalf("mode=memory database must not get a lock file, got %q", f)
+	}
+	r := migrator.NewRunner(db)
+	if err := r.Up(ctx, []migrator.Step{step(1, "one", "CREATE TABLE one(id INTEGER);", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob("file:x*"); len(files) != 0 {
+		t.Fatalf("no files must be created for an in-memory database, got %v", files)
+	}
+}
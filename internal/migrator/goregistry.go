@@ -2,19 +2,52 @@
 package migrator
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 )
 
-// GoStep represents a single Go-based migration step.
+// GoStep represents a single Go-based migration step. Transactional steps set
+// Up/Down; steps that must run outside a transaction set UpNoTx/DownNoTx instead.
 type GoStep struct {
-	Version int64
-	Name    string
-	Up      func(pgx.Tx) error
-	Down    func(pgx.Tx) error
+	Version  int64
+	Name     string
+	Checksum string
+	Up       GoTxFunc
+	Down     GoTxFunc
+	UpNoTx   GoPoolFunc
+	DownNoTx GoPoolFunc
 }
 
+// Step converts the Go migration into a unified Step.
+func (s GoStep) Step() Step {
+	return Step{
+		Version:       s.Version,
+		Name:          s.Name,
+		Kind:          KindGo,
+		UpFn:          s.Up,
+		DownFn:        s.Down,
+		UpPoolFn:      s.UpNoTx,
+		DownPoolFn:    s.DownNoTx,
+		Checksum:      s.Checksum,
+		NoTransaction: s.UpNoTx != nil || s.DownNoTx != nil,
+	}
+}
+
+// withoutContext адаптирует функцию старой сигнатуры func(pgx.Tx) error.
+func withoutContext(fn func(pgx.Tx) error) GoTxFunc {
+	if fn == nil {
+		return nil
+	}
+	return func(_ context.Context, tx pgx.Tx) error { return fn(tx) }
+}
+
+// SourceChecksum returns the SHA-256 of a Go migration's source text.
+func SourceChecksum(src []byte) string { return checksum(string(src)) }
+
 // Registry stores registered Go migrations.
 type Registry struct {
 	byVersion map[int64]GoStep
@@ -23,15 +56,72 @@ type Registry struct {
 // NewRegistry creates a new Registry instance.
 func NewRegistry() *Registry { return &Registry{byVersion: map[int64]GoStep{}} }
 
-// Register adds a new Go migration to the registry.
-func (r *Registry) Register(ver int64, name string, up func(pgx.Tx) error, down func(pgx.Tx) error) error {
-	if _, exists := r.byVersion[ver]; exists {
-		return fmt.Errorf("go migration %d already registered", ver)
+// Register adds a new Go migration whose functions do not receive a context.
+// sum is the migration fingerprint and must change whenever the migration logic
+// changes, e.g. SourceChecksum of the embedded source.
+func (r *Registry) Register(ver int64, name, sum string, up func(pgx.Tx) error, down func(pgx.Tx) error) error {
+	return r.RegisterContext(ver, name, sum, withoutContext(up), withoutContext(down))
+}
+
+// RegisterContext adds a transactional Go migration that receives the runner's context.
+func (r *Registry) RegisterContext(ver int64, name, sum string, up, down GoTxFunc) error {
+	return r.add(GoStep{Version: ver, Name: name, Checksum: sum, Up: up, Down: down})
+}
+
+// RegisterNoTx adds a Go migration that runs outside a transaction on the pool,
+// e.g. for batched backfills that commit as they go.
+func (r *Registry) RegisterNoTx(ver int64, name, sum string, up, down GoPoolFunc) error {
+	if up == nil && down == nil {
+		return fmt.Errorf("go migration %d: no functions", ver)
 	}
-	r.byVersion[ver] = GoStep{Version: ver, Name: name, Up: up, Down: down}
+	return r.add(GoStep{Version: ver, Name: name, Checksum: sum, UpNoTx: up, DownNoTx: down})
+}
+
+func (r *Registry) add(s GoStep) error {
+	if _, exists := r.byVersion[s.Version]; exists {
+		return fmt.Errorf("go migration %d already registered", s.Version)
+	}
+	if s.Checksum == "" {
+		return fmt.Errorf("go migration %d: empty checksum", s.Version)
+	}
+	r.byVersion[s.Version] = s
 	return nil
 }
 
+// GoSteps converts Go migrations into unified Steps.
+func GoSteps(steps []GoStep) []Step {
+	out := make([]Step, 0, len(steps))
+	for _, s := range steps {
+		out = append(out, s.Step())
+	}
+	return out
+}
+
+// MergeSteps merges SQL and Go migrations into a single timeline ordered by version.
+// A version defined by both sources is rejected.
+func MergeSteps(sqlSteps []Step, goSteps []GoStep) ([]Step, error) {
+	out := make([]Step, 0, len(sqlSteps)+len(goSteps))
+	seen := make(map[int64]Step, len(sqlSteps))
+	for _, s := range sqlSteps {
+		seen[s.Version] = s
+		out = append(out, s)
+	}
+	var dups []string
+	for _, g := range goSteps {
+		if s, ok := seen[g.Version]; ok {
+			dups = append(dups, fmt.Sprintf("%d (sql %s, go %s)", g.Version, s.Name, g.Name))
+			continue
+		}
+		out = append(out, g.Step())
+	}
+	if len(dups) > 0 {
+		sort.Strings(dups)
+		return nil, fmt.Errorf("migration versions defined in both sql and go: %s", strings.Join(dups, ", "))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
 // Steps returns all registered Go migrations.
 func (r *Registry) Steps() []GoStep {
 	out := make([]GoStep, 0, len(r.byVersion))
@@ -1,25 +1,96 @@
 package migrator
 
 import (
-	"github.com/jackc/pgx/v5"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func TestRegistry_RegisterAndSteps(t *testing.T) {
 	r := NewRegistry()
 	up := func(pgx.Tx) error { return nil }
 	down := func(pgx.Tx) error { return nil }
-	if err := r.Register(1, "one", up, down); err != nil {
+	if err := r.Register(1, "one", "sum1", up, down); err != nil {
 		t.Fatalf("register failed: %v", err)
 	}
-	if err := r.Register(2, "two", up, nil); err != nil {
+	if err := r.Register(2, "two", "sum2", up, nil); err != nil {
 		t.Fatalf("register failed: %v", err)
 	}
-	if err := r.Register(1, "dup", up, down); err == nil {
+	if err := r.Register(1, "dup", "sum1", up, down); err == nil {
 		t.Fatalf("expected duplicate error")
 	}
 	steps := r.Steps()
 	if len(steps) != 2 {
 		t.Fatalf("expected 2 steps, got %d", len(steps))
 	}
+	for _, s := range steps {
+		if want := fmt.Sprintf("sum%d", s.Version); s.Checksum != want {
+			t.Fatalf("expected checksum %s for %d, got %q", want, s.Version, s.Checksum)
+		}
+	}
+	if err := r.Register(3, "three", "", up, nil); err == nil {
+		t.Fatalf("expected empty checksum error")
+	}
+}
+
+func TestMergeSteps(t *testing.T) {
+	sqlSteps := []Step{
+		{Version: 1, Name: "init", Kind: KindSQL},
+		{Version: 3, Name: "index", Kind: KindSQL},
+	}
+	goSteps := []GoStep{{Version: 2, Name: "backfill"}}
+	steps, err := MergeSteps(sqlSteps, goSteps)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(steps))
+	}
+	for i, want := range []int64{1, 2, 3} {
+		if steps[i].Version != want {
+			t.Fatalf("step %d: expected version %d, got %d", i, want, steps[i].Version)
+		}
+	}
+	if steps[1].Kind != KindGo {
+		t.Fatalf("expected go step in the middle, got %q", steps[1].Kind)
+	}
+
+	if _, err := MergeSteps(sqlSteps, []GoStep{{Version: 3, Name: "dup"}}); err == nil {
+		t.Fatalf("expected collision error")
+	}
+}
+
+func TestRegistry_ContextAndNoTx(t *testing.T) {
+	r := NewRegistry()
+	type key struct{}
+	var got any
+	up := func(ctx context.Context, _ pgx.Tx) error { got = ctx.Value(key{}); return nil }
+	if err := r.RegisterContext(1, "ctx", "sum", up, nil); err != nil {
+		t.Fatal(err)
+	}
+	pool := func(context.Context, *pgxpool.Pool) error { return nil }
+	if err := r.RegisterNoTx(2, "batch", "sum", pool, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterNoTx(3, "empty", "sum", nil, nil); err == nil {
+		t.Fatal("expected error for no-tx migration without functions")
+	}
+	steps := GoSteps(r.Steps())
+	sort.Slice(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
+	if steps[0].NoTransaction || !steps[1].NoTransaction {
+		t.Fatalf("unexpected NoTransaction flags: %v %v", steps[0].NoTransaction, steps[1].NoTransaction)
+	}
+	ctx := context.WithValue(context.Background(), key{}, "runner")
+	if err := steps[0].UpFn(ctx, nil); err != nil || got != "runner" {
+		t.Fatalf("context was not propagated: %v %v", got, err)
+	}
+	// без драйвера PostgreSQL пул недоступен
+	if err := steps[1].body(true)(ctx, nil); !errors.Is(err, errNoPgx) {
+		t.Fatalf("expected errNoPgx, got %v", err)
+	}
 }
@@ -0,0 +1,139 @@
+package migrator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// RunInfo identifies the process writing history entries.
+type RunInfo struct {
+	ID          string
+	Hostname    string
+	OSUser      string
+	ToolVersion string
+}
+
+// NewRunInfo describes the current process with a fresh random run ID.
+func NewRunInfo() RunInfo {
+	info := RunInfo{ID: newRunID(), ToolVersion: toolVersion()}
+	info.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		info.OSUser = u.Username
+	}
+	return info
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// modulePath — путь модуля мигратора в сборочной информации.
+const modulePath = "migrator"
+
+// toolVersion возвращает версию модуля мигратора из сборочной информации:
+// основного модуля для CLI или зависимости для приложения, встроившего библиотеку.
+func toolVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// HistorySelect is the column list for reading history entries; drivers scan it
+// with ScanHistory.
+const HistorySelect = `id, version, action, COALESCE(direction, ''), COALESCE(checksum, ''), COALESCE(duration_ms, 0),
+COALESCE(error_text, ''), COALESCE(details, ''), COALESCE(hostname, ''), COALESCE(os_user, ''),
+COALESCE(tool_version, ''), COALESCE(run_id, ''), created_at`
+
+// ScanHistory reads a row selected with HistorySelect.
+func ScanHistory(scan func(dest ...any) error) (HistoryEntry, error) {
+	var (
+		e  HistoryEntry
+		ms int64
+	)
+	err := scan(&e.ID, &e.Version, &e.Action, &e.Direction, &e.Checksum, &ms, &e.Error, &e.Details,
+		&e.Hostname, &e.OSUser, &e.ToolVersion, &e.RunID, &e.CreatedAt)
+	e.Duration = time.Duration(ms) * time.Millisecond
+	return e, err
+}
+
+// HistoryQuery builds the SELECT for f; placeholder(i) returns the driver's i-th
+// (1-based) placeholder.
+func HistoryQuery(table string, f HistoryFilter, placeholder func(int) string) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Version != 0 {
+		args = append(args, f.Version)
+		where = append(where, "version = "+placeholder(len(args)))
+	}
+	if f.RunID != "" {
+		args = append(args, f.RunID)
+		where = append(where, "run_id = "+placeholder(len(args)))
+	}
+	q := "SELECT " + HistorySelect + " FROM " + table
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return q, args
+}
+
+// History returns history entries matching f, newest first.
+func (r *Runner) History(ctx context.Context, f HistoryFilter) ([]HistoryEntry, error) {
+	return r.DB.LoadHistory(ctx, f)
+}
+
+// audit добавляет запись в журнал, дополняя ее сведениями о запуске.
+func (r *Runner) audit(ctx context.Context, c Conn, e HistoryEntry) error {
+	e.Hostname, e.OSUser, e.ToolVersion, e.RunID = r.Run.Hostname, r.Run.OSUser, r.Run.ToolVersion, r.Run.ID
+	return c.AddHistory(ctx, e)
+}
+
+// stepEntry заготавливает запись журнала о выполнении шага.
+func stepEntry(s Step, up bool, action string, dur time.Duration) HistoryEntry {
+	return HistoryEntry{Version: s.Version, Action: action, Direction: direction(up), Checksum: s.Checksum, Duration: dur, Details: s.Name}
+}
+
+// recordFailure записывает неудачу шага: статус failed (если rec задан) и запись журнала.
+// Сначала используется текущее соединение c, затем — новое соединение без отмены:
+// после отмены контекста или отката транзакции c может быть непригодно.
+func (r *Runner) recordFailure(ctx context.Context, c Conn, rec *Record, e HistoryEntry, cause error) {
+	e.Error = cause.Error()
+	write := func(ctx context.Context, c Conn) error {
+		if rec != nil {
+			if err := markFailed(ctx, c, *rec, cause); err != nil {
+				return err
+			}
+		}
+		return r.audit(ctx, c, e)
+	}
+	if c != nil && write(ctx, c) == nil {
+		return
+	}
+	bg, cancel := context.WithTimeout(context.WithoutCancel(ctx), bookkeepingTimeout)
+	defer cancel()
+	_ = r.DB.WithConn(bg, func(c Conn) error { return write(bg, c) })
+}
@@ -0,0 +1,371 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity is the level of a lint rule.
+type Severity string
+
+// Lint rule severities. Errors fail the lint command, warnings are only printed.
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityOff     Severity = "off"
+)
+
+// LintAll in a nolint directive suppresses every rule.
+const LintAll = "all"
+
+// Names of the lint rules.
+const (
+	RuleCreateIndex     = "create-index-concurrently"
+	RuleVolatileDefault = "volatile-default"
+	RuleColumnType      = "alter-column-type"
+	RuleSetNotNull      = "set-not-null"
+	RuleDropInUp        = "drop-in-up"
+	RuleMissingDown     = "missing-down"
+)
+
+// LintRule describes a lint rule and its default severity.
+type LintRule struct {
+	Name        string
+	Severity    Severity
+	Description string
+}
+
+// LintRules lists the built-in rules. The checks target PostgreSQL locking.
+var LintRules = []LintRule{
+	{RuleCreateIndex, SeverityError, "CREATE INDEX without CONCURRENTLY blocks writes to the table while the index is built; CONCURRENTLY needs NoTransaction"},
+	{RuleVolatileDefault, SeverityError, "ADD COLUMN with a volatile default, serial or stored generated column rewrites the table"},
+	{RuleColumnType, SeverityError, "ALTER COLUMN ... TYPE usually rewrites the table under an exclusive lock"},
+	{RuleSetNotNull, SeverityError, "SET NOT NULL scans the table under an exclusive lock unless a CHECK (col IS NOT NULL) constraint exists"},
+	{RuleDropInUp, SeverityWarning, "DROP TABLE or DROP COLUMN in Up breaks code still reading the data and cannot be rolled back"},
+	{RuleMissingDown, SeverityWarning, "Down does not revert what Up creates"},
+}
+
+// LintFinding is a single rule violation.
+type LintFinding struct {
+	Rule     string
+	Severity Severity
+	Version  int64
+	Name     string
+	// Line is the line of the statement in the migration file; 0 for Go migrations
+	// and findings about the whole step.
+	Line    int
+	Message string
+}
+
+// String formats the finding as version_name:line: severity: message [rule].
+func (f LintFinding) String() string {
+	loc := fmt.Sprintf("%d_%s", f.Version, f.Name)
+	if f.Line > 0 {
+		loc += fmt.Sprintf(":%d", f.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", loc, f.Severity, f.Message, f.Rule)
+}
+
+// Lint checks the Up sections of steps for operations that lock or rewrite big
+// tables and reports steps whose Down does not revert Up. severity overrides the
+// default severity of rules by name; SeverityOff disables a rule. Findings are
+// dropped for rules suppressed by a step's nolint directive.
+func Lint(steps []Step, severity map[string]Severity) ([]LintFinding, error) {
+	levels := make(map[string]Severity, len(LintRules))
+	for _, r := range LintRules {
+		levels[r.Name] = r.Severity
+	}
+	for name, s := range severity {
+		if _, ok := levels[name]; !ok {
+			return nil, fmt.Errorf("unknown lint rule %q", name)
+		}
+		if s != SeverityError && s != SeverityWarning && s != SeverityOff {
+			return nil, fmt.Errorf("lint rule %s: unknown severity %q, expected error, warning or off", name, s)
+		}
+		levels[name] = s
+	}
+	sorted := append([]Step(nil), steps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	var out []LintFinding
+	// CHECK (col IS NOT NULL) из предыдущих миграций делает SET NOT NULL безопасным
+	checks := map[string]bool{}
+	for _, s := range sorted {
+		l := stepLinter{step: s, checks: checks}
+		if err := l.run(); err != nil {
+			return nil, fmt.Errorf("%d_%s: %w", s.Version, s.Name, err)
+		}
+		skip := map[string]bool{}
+		for _, name := range s.NoLint {
+			if _, ok := levels[name]; !ok && name != LintAll {
+				// опечатка в nolint иначе молча ничего не подавит
+				out = append(out, LintFinding{Rule: "nolint", Severity: SeverityError, Version: s.Version, Name: s.Name,
+					Message: fmt.Sprintf("unknown rule %q in the nolint directive", name)})
+			}
+			skip[name] = true
+		}
+		for _, f := range l.found {
+			if skip[LintAll] || skip[f.Rule] || levels[f.Rule] == SeverityOff {
+				continue
+			}
+			f.Severity, f.Version, f.Name = levels[f.Rule], s.Version, s.Name
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+var (
+	// ident — имя, возможно со схемой и в двойных кавычках
+	ident = `(?:"[^"]+"|[\w$]+)(?:\.(?:"[^"]+"|[\w$]+))*`
+
+	reCreateTable = regexp.MustCompile(`(?i)^CREATE\s+(?:(?:GLOBAL|LOCAL)\s+)?(?:UNLOGGED\s+|TEMP\s+|TEMPORARY\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(` + ident + `)`)
+	reCreateIndex = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:(` + ident + `)\s+)?ON\s+(?:ONLY\s+)?(` + ident + `)`)
+	reAlterTable  = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(` + ident + `)\s+(.*)$`)
+	reDropTable   = regexp.MustCompile(`(?i)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(.*?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	reDropIndex   = regexp.MustCompile(`(?i)^DROP\s+INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+EXISTS\s+)?(.*?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+
+	reAddColumn   = regexp.MustCompile(`(?i)^ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(` + ident + `)\s+(.*)$`)
+	reAlterType   = regexp.MustCompile(`(?i)^ALTER\s+(?:COLUMN\s+)?(` + ident + `)\s+(?:SET\s+DATA\s+)?TYPE\b`)
+	reSetNotNull  = regexp.MustCompile(`(?i)^ALTER\s+(?:COLUMN\s+)?(` + ident + `)\s+SET\s+NOT\s+NULL`)
+	reDropColumn  = regexp.MustCompile(`(?i)^DROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?(` + ident + `)`)
+	reNotNullChk  = regexp.MustCompile(`(?i)CHECK\s*\(\s*(` + ident + `)\s+IS\s+NOT\s+NULL\s*\)`)
+	reVolatile    = regexp.MustCompile(`(?i)\bDEFAULT\s+.*\b(random|gen_random_uuid|uuid_generate_v[14]|clock_timestamp|timeofday|nextval|txid_current)\s*\(`)
+	reSerial      = regexp.MustCompile(`(?i)^(?:small|big)?serial\b`)
+	reStoredGen   = regexp.MustCompile(`(?i)\bGENERATED\s+ALWAYS\s+AS\s*\(.*\)\s*STORED\b`)
+	constraintKWs = map[string]bool{"CONSTRAINT": true, "CHECK": true, "PRIMARY": true, "UNIQUE": true, "FOREIGN": true, "EXCLUDE": true}
+)
+
+// stepLinter проверяет одну миграцию; found заполняется без уровня и версии.
+type stepLinter struct {
+	step   Step
+	checks map[string]bool
+	found  []LintFinding
+
+	// объекты, созданные в Up: новые таблицы пусты, их блокировка никому не мешает
+	tables  map[string]int
+	indexes map[string]int
+	indexOn map[string]string
+	columns map[string]int
+}
+
+func (l *stepLinter) report(rule string, line int, format string, args ...any) {
+	l.found = append(l.found, LintFinding{Rule: rule, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *stepLinter) run() error {
+	if l.step.Kind == KindGo {
+		if l.step.DownFn == nil && l.step.DownPoolFn == nil {
+			l.report(RuleMissingDown, 0, "go migration has no Down function")
+		}
+		return nil
+	}
+	// правила линтера рассчитаны на PostgreSQL
+	up, err := l.step.statements(true, SyntaxPostgres)
+	if err != nil {
+		return err
+	}
+	l.tables, l.indexes, l.indexOn, l.columns = map[string]int{}, map[string]int{}, map[string]string{}, map[string]int{}
+	for _, st := range up {
+		l.upStatement(normalizeSQL(st.SQL), st.Line)
+	}
+	down, err := l.step.statements(false, SyntaxPostgres)
+	if err != nil {
+		return err
+	}
+	l.checkDown(down, up)
+	// карты объектов обходятся в случайном порядке
+	sort.Slice(l.found, func(i, j int) bool {
+		if l.found[i].Line != l.found[j].Line {
+			return l.found[i].Line < l.found[j].Line
+		}
+		return l.found[i].Message < l.found[j].Message
+	})
+	return nil
+}
+
+func (l *stepLinter) upStatement(sql string, line int) {
+	if m := reCreateTable.FindStringSubmatch(sql); m != nil {
+		l.tables[objectName(m[1])] = line
+		return
+	}
+	if m := reCreateIndex.FindStringSubmatch(sql); m != nil {
+		table := objectName(m[3])
+		if m[2] != "" {
+			l.indexes[objectName(m[2])] = line
+			l.indexOn[objectName(m[2])] = table
+		}
+		if _, fresh := l.tables[table]; m[1] == "" && !fresh {
+			l.report(RuleCreateIndex, line, "CREATE INDEX on %s without CONCURRENTLY blocks writes; use CREATE INDEX CONCURRENTLY in a NoTransaction migration", table)
+		}
+		// PostgreSQL отказывается строить индекс CONCURRENTLY внутри транзакции
+		if m[1] != "" && !l.step.NoTransaction {
+			l.report(RuleCreateIndex, line, "CREATE INDEX CONCURRENTLY on %s fails inside a transaction; add -- +migrate NoTransaction", table)
+		}
+		return
+	}
+	if m := reDropTable.FindStringSubmatch(sql); m != nil {
+		l.report(RuleDropInUp, line, "DROP TABLE %s in Up", strings.TrimSpace(m[1]))
+		return
+	}
+	m := reAlterTable.FindStringSubmatch(sql)
+	if m == nil {
+		return
+	}
+	table := objectName(m[1])
+	_, fresh := l.tables[table]
+	for _, action := range splitTopLevel(m[2]) {
+		first, _, _ := strings.Cut(action, " ")
+		first = strings.ToUpper(first)
+		if c := reNotNullChk.FindStringSubmatch(action); c != nil && first == "ADD" {
+			l.checks[table+"."+objectName(c[1])] = true
+		}
+		switch {
+		case first == "ADD":
+			rest := strings.TrimSpace(action[len(first):])
+			kw, _, _ := strings.Cut(rest, " ")
+			if constraintKWs[strings.ToUpper(kw)] {
+				continue
+			}
+			c := reAddColumn.FindStringSubmatch(action)
+			if c == nil {
+				continue
+			}
+			col := objectName(c[1])
+			l.columns[table+"."+col] = line
+			if fresh {
+				continue
+			}
+			switch {
+			case reVolatile.MatchString(c[2]):
+				l.report(RuleVolatileDefault, line, "column %s.%s is added with a volatile DEFAULT, which rewrites the table; add it without a default and backfill in batches", table, col)
+			case reSerial.MatchString(c[2]):
+				l.report(RuleVolatileDefault, line, "serial column %s.%s rewrites the table", table, col)
+			case reStoredGen.MatchString(c[2]):
+				l.report(RuleVolatileDefault, line, "stored generated column %s.%s rewrites the table", table, col)
+			}
+		case first == "ALTER":
+			if fresh {
+				continue
+			}
+			if c := reAlterType.FindStringSubmatch(action); c != nil {
+				l.report(RuleColumnType, line, "changing the type of %s.%s may rewrite the table under an ACCESS EXCLUSIVE lock", table, objectName(c[1]))
+			} else if c := reSetNotNull.FindStringSubmatch(action); c != nil {
+				col := objectName(c[1])
+				if !l.checks[table+"."+col] {
+					l.report(RuleSetNotNull, line, "SET NOT NULL on %s.%s scans the table under an ACCESS EXCLUSIVE lock; first add CHECK (%s IS NOT NULL) NOT VALID and validate it", table, col, col)
+				}
+			}
+		case first == "DROP":
+			rest := strings.TrimSpace(action[len(first):])
+			kw, _, _ := strings.Cut(rest, " ")
+			if kw = strings.ToUpper(kw); kw == "CONSTRAINT" {
+				continue
+			}
+			if c := reDropColumn.FindStringSubmatch(action); c != nil {
+				l.report(RuleDropInUp, line, "DROP COLUMN %s.%s in Up", table, objectName(c[1]))
+			}
+		}
+	}
+}
+
+// checkDown сообщает о таблицах, индексах и колонках из Up, которые Down не удаляет.
+func (l *stepLinter) checkDown(down, up []Statement) {
+	if len(down) == 0 {
+		if len(up) > 0 {
+			l.report(RuleMissingDown, 0, "migration has no Down statements")
+		}
+		return
+	}
+	dropped := map[string]bool{}
+	for _, st := range down {
+		sql := normalizeSQL(st.SQL)
+		if m := reDropTable.FindStringSubmatch(sql); m != nil {
+			for _, name := range splitTopLevel(m[1]) {
+				dropped["table "+objectName(name)] = true
+			}
+		} else if m := reDropIndex.FindStringSubmatch(sql); m != nil {
+			for _, name := range splitTopLevel(m[1]) {
+				dropped["index "+objectName(name)] = true
+			}
+		} else if m := reAlterTable.FindStringSubmatch(sql); m != nil {
+			table := objectName(m[1])
+			for _, action := range splitTopLevel(m[2]) {
+				if c := reDropColumn.FindStringSubmatch(action); c != nil {
+					dropped["column "+table+"."+objectName(c[1])] = true
+				}
+			}
+		}
+	}
+	for name, line := range l.tables {
+		if !dropped["table "+name] {
+			l.report(RuleMissingDown, line, "Down does not drop table %s created in Up", name)
+		}
+	}
+	for name, line := range l.indexes {
+		if !dropped["index "+name] && !dropped["table "+l.indexOn[name]] {
+			l.report(RuleMissingDown, line, "Down does not drop index %s created in Up", name)
+		}
+	}
+	for name, line := range l.columns {
+		table, _, _ := strings.Cut(name, ".")
+		if !dropped["column "+name] && !dropped["table "+table] {
+			l.report(RuleMissingDown, line, "Down does not drop column %s added in Up", name)
+		}
+	}
+}
+
+// normalizeSQL убирает комментарии и схлопывает пробелы, чтобы правила могли
+// сопоставлять выражение регулярными выражениями. Лексемы распознает тот же
+// lexer, что делит миграции на выражения, так что `--`, `;` и запятые внутри
+// строк и dollar-тел не сбивают разбор.
+func normalizeSQL(sql string) string {
+	var b strings.Builder
+	lex := lexer{syntax: SyntaxPostgres}
+	for i := 0; i < len(sql); {
+		kind, n := lex.next(sql, i)
+		if kind == tokComment {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(sql[i : i+n])
+		}
+		i += n
+	}
+	return strings.TrimSuffix(strings.Join(strings.Fields(b.String()), " "), ";")
+}
+
+// splitTopLevel делит список по запятым вне скобок, строк и dollar-тел.
+func splitTopLevel(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	lex := lexer{syntax: SyntaxPostgres}
+	for i := 0; i < len(s); {
+		kind, n := lex.next(s, i)
+		if kind == tokCode {
+			switch s[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					out = append(out, strings.TrimSpace(s[start:i]))
+					start = i + 1
+				}
+			}
+		}
+		i += n
+	}
+	return append(out, strings.TrimSpace(s[start:]))
+}
+
+// objectName приводит имя к виду для сравнения: без схемы и кавычек, в нижнем регистре.
+func objectName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Trim(name, `"`))
+}
@@ -0,0 +1,147 @@
+package migrator
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLint(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_users.sql": mapFile(`-- +migrate Up
+CREATE TABLE users (id bigint PRIMARY KEY, email text);
+CREATE INDEX users_email ON users (email);
+-- +migrate Down
+DROP TABLE users;
+`),
+		"2_index.sql": mapFile(`-- +migrate Up
+CREATE INDEX users_email_lower ON users (lower(email));
+-- +migrate Down
+DROP INDEX users_email_lower;
+`),
+		"3_columns.sql": mapFile(`-- +migrate Up
+ALTER TABLE users ADD COLUMN token uuid DEFAULT gen_random_uuid(), ADD COLUMN note text;
+ALTER TABLE public."users" ALTER COLUMN email TYPE varchar(320);
+-- +migrate Down
+ALTER TABLE users DROP COLUMN token;
+`),
+		"4_check.sql": mapFile(`-- +migrate Up
+ALTER TABLE users ADD CONSTRAINT email_nn CHECK (email IS NOT NULL) NOT VALID;
+-- +migrate Down
+ALTER TABLE users DROP CONSTRAINT email_nn;
+`),
+		"5_not_null.sql": mapFile(`-- +migrate Up
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
+ALTER TABLE users ALTER COLUMN note SET NOT NULL;
+-- +migrate Down
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+`),
+		"6_drop.sql": mapFile(`-- +migrate Up
+-- +migrate nolint drop-in-up, missing-down
+ALTER TABLE users DROP COLUMN note;
+DROP TABLE legacy;
+`),
+		"7_drop.sql": mapFile(`-- +migrate Up
+-- +migrate nolint drop-in-upp
+DROP TABLE legacy2 CASCADE;
+`),
+		"9_concurrently.sql": mapFile(`-- +migrate Up
+CREATE INDEX CONCURRENTLY users_note ON users (note);
+-- +migrate Down
+DROP INDEX users_note;
+`),
+		"90_concurrently.sql": mapFile(`-- +migrate NoTransaction
+-- +migrate Up
+CREATE INDEX CONCURRENTLY users_token ON users (token);
+-- +migrate Down
+DROP INDEX CONCURRENTLY users_token;
+`),
+	}
+	steps, err := ParseSQLFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goStep := GoStep{Version: 8, Name: "backfill"}.Step()
+	steps = append(steps, goStep)
+
+	findings, err := Lint(steps, map[string]Severity{RuleColumnType: SeverityWarning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"2_index:2: error: CREATE INDEX on users without CONCURRENTLY blocks writes; use CREATE INDEX CONCURRENTLY in a NoTransaction migration [create-index-concurrently]",
+		"3_columns:2: warning: Down does not drop column users.note added in Up [missing-down]",
+		"3_columns:2: error: column users.token is added with a volatile DEFAULT, which rewrites the table; add it without a default and backfill in batches [volatile-default]",
+		"3_columns:3: warning: changing the type of users.email may rewrite the table under an ACCESS EXCLUSIVE lock [alter-column-type]",
+		"5_not_null:3: error: SET NOT NULL on users.note scans the table under an ACCESS EXCLUSIVE lock; first add CHECK (note IS NOT NULL) NOT VALID and validate it [set-not-null]",
+		"7_drop: error: unknown rule \"drop-in-upp\" in the nolint directive [nolint]",
+		"7_drop: warning: migration has no Down statements [missing-down]",
+		"7_drop:3: warning: DROP TABLE legacy2 in Up [drop-in-up]",
+		"8_backfill: warning: go migration has no Down function [missing-down]",
+		"9_concurrently:2: error: CREATE INDEX CONCURRENTLY on users fails inside a transaction; add -- +migrate NoTransaction [create-index-concurrently]",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d findings:\n%q", len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("finding %d:\n got %q\nwant %q", i, got[i], want[i])
+		}
+	}
+
+	if _, err := Lint(steps, map[string]Severity{"no-such-rule": SeverityOff}); err == nil {
+		t.Error("expected error for an unknown rule")
+	}
+	if _, err := Lint(steps, map[string]Severity{RuleDropInUp: "fatal"}); err == nil {
+		t.Error("expected error for an unknown severity")
+	}
+	findings, _ = Lint(steps, map[string]Severity{RuleMissingDown: SeverityOff, RuleCreateIndex: SeverityOff})
+	for _, f := range findings {
+		if f.Rule == RuleMissingDown || f.Rule == RuleCreateIndex {
+			t.Errorf("disabled rule reported: %s", f)
+		}
+	}
+}
+
+func TestLint_DollarQuotedBodies(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_func.sql": mapFile(`-- +migrate Up
+CREATE FUNCTION cleanup() RETURNS void AS $$
+BEGIN
+  -- don't keep the scratch table
+  DROP TABLE tmp;
+  ALTER TABLE users ALTER COLUMN email TYPE text, ADD COLUMN x int;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate Down
+DROP FUNCTION cleanup();
+`),
+		"2_defaults.sql": mapFile(`-- +migrate Up
+ALTER TABLE users ADD COLUMN note text DEFAULT $$a, ALTER COLUMN id TYPE text$$;
+ALTER TABLE users ADD COLUMN quote text DEFAULT E'it\'s, -- not a comment', ALTER COLUMN email TYPE varchar(320);
+-- +migrate Down
+ALTER TABLE users DROP COLUMN note, DROP COLUMN quote;
+`),
+	}
+	steps, err := ParseSQLFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := Lint(steps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"2_defaults:3: error: changing the type of users.email may rewrite the table under an ACCESS EXCLUSIVE lock [alter-column-type]",
+	}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("got findings:\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LockHolder describes a database session holding the migrator lock.
+type LockHolder struct {
+	PID             int64
+	User            string
+	ApplicationName string
+	ClientAddr      string
+	State           string
+	Query           string
+	QueryStart      *time.Time
+}
+
+func (h LockHolder) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pid %d", h.PID)
+	for _, kv := range [][2]string{{"user", h.User}, {"application", h.ApplicationName}, {"client", h.ClientAddr}, {"state", h.State}} {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, ", %s %s", kv[0], kv[1])
+		}
+	}
+	if h.QueryStart != nil {
+		fmt.Fprintf(&b, ", since %s", h.QueryStart.Format(time.RFC3339))
+	}
+	if q := strings.Join(strings.Fields(h.Query), " "); q != "" {
+		fmt.Fprintf(&b, ", query: %s", q)
+	}
+	return b.String()
+}
+
+// LockTimeoutError is returned when the migrator lock is not acquired within LockOptions.Timeout.
+type LockTimeoutError struct {
+	Timeout time.Duration
+	Holders []LockHolder
+}
+
+func (e *LockTimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out after %s waiting for the migration lock", e.Timeout)
+	if len(e.Holders) == 0 {
+		return msg
+	}
+	held := make([]string, 0, len(e.Holders))
+	for _, h := range e.Holders {
+		held = append(held, h.String())
+	}
+	return msg + "; held by " + strings.Join(held, "; ")
+}
+
+// LockOptions configures waiting for the migrator lock.
+type LockOptions struct {
+	// Timeout limits the wait; zero waits until the context is done.
+	Timeout time.Duration
+	// OnWait is called once when the lock turns out to be busy.
+	OnWait func([]LockHolder)
+}
+
+// lockPollInterval — пауза между попытками взять блокировку.
+var lockPollInterval = 500 * time.Millisecond
+
+// AcquireLock polls try until it succeeds, ctx is done, a stop is requested
+// through WithStop or opts.Timeout elapses.
+// When the lock is busy, holders is used to report the sessions holding it.
+func AcquireLock(ctx context.Context, opts LockOptions, try func(context.Context) (bool, error), holders func(context.Context) ([]LockHolder, error)) error {
+	var deadline <-chan time.Time
+	if opts.Timeout > 0 {
+		t := time.NewTimer(opts.Timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	reported := false
+	for {
+		ok, err := try(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if !reported && opts.OnWait != nil {
+			// диагностика не должна мешать ожиданию: ошибку запроса игнорируем
+			hs, _ := holders(ctx)
+			opts.OnWait(hs)
+		}
+		reported = true
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-stopDone(ctx):
+			// ни одна миграция еще не начата, так что ждать блокировку дальше незачем
+			return fmt.Errorf("%w while waiting for the migration lock: %w", ErrInterrupted, stopped(ctx))
+		case <-deadline:
+			hs, _ := holders(context.WithoutCancel(ctx))
+			return &LockTimeoutError{Timeout: opts.Timeout, Holders: hs}
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	lockPollInterval = time.Millisecond
+	defer func() { lockPollInterval = 500 * time.Millisecond }()
+	holder := []LockHolder{{PID: 7, ApplicationName: "deploy"}}
+	holders := func(context.Context) ([]LockHolder, error) { return holder, nil }
+
+	attempts := 0
+	var waited []LockHolder
+	try := func(context.Context) (bool, error) { attempts++; return attempts == 3, nil }
+	opts := LockOptions{Timeout: time.Minute, OnWait: func(hs []LockHolder) { waited = append(waited, hs...) }}
+	if err := AcquireLock(context.Background(), opts, try, holders); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(waited) != 1 {
+		t.Fatalf("expected 3 attempts and one wait report, got %d and %+v", attempts, waited)
+	}
+
+	busy := func(context.Context) (bool, error) { return false, nil }
+	err := AcquireLock(context.Background(), LockOptions{Timeout: 20 * time.Millisecond}, busy, holders)
+	var lte *LockTimeoutError
+	if !errors.As(err, &lte) || !strings.Contains(err.Error(), "pid 7, application deploy") {
+		t.Fatalf("expected lock timeout with holder, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := AcquireLock(ctx, LockOptions{}, busy, holders); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+
+	// первый сигнал (запрос остановки) прерывает бесконечное ожидание
+	stop, stopNow := context.WithCancel(context.Background())
+	stopNow()
+	err = AcquireLock(WithStop(context.Background(), stop), LockOptions{}, busy, holders)
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected interruption while waiting, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OutOfOrderError is returned by Up when pending migrations are older than the
+// newest applied one and out-of-order application is not allowed.
+type OutOfOrderError struct {
+	Steps []Step
+}
+
+func (e *OutOfOrderError) Error() string {
+	ids := make([]string, 0, len(e.Steps))
+	for _, s := range e.Steps {
+		ids = append(ids, fmt.Sprintf("%d_%s", s.Version, s.Name))
+	}
+	return fmt.Sprintf("pending migrations are older than the current database version: %s (set allow_out_of_order to apply them)", strings.Join(ids, ", "))
+}
+
+// outOfOrder возвращает ожидающие шаги с версией меньше максимальной применённой.
+func outOfOrder(pending []Step, applied map[int64]string) []Step {
+	var maxApplied int64 = -1
+	for v := range applied {
+		if v > maxApplied {
+			maxApplied = v
+		}
+	}
+	var out []Step
+	for _, s := range pending {
+		if s.Version < maxApplied {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// appliedOutOfOrder возвращает версии, применённые позже какой-либо более новой версии.
+func appliedOutOfOrder(recs []Record) map[int64]struct{} {
+	out := map[int64]struct{}{}
+	byVersion := make([]Record, 0, len(recs))
+	for _, rec := range recs {
+		if rec.Status == StatusApplied && rec.AppliedAt != nil {
+			byVersion = append(byVersion, rec)
+		}
+	}
+	// recs отсортированы по версии; идём от новых к старым, запоминая самое раннее время применения
+	var earliest *time.Time
+	for i := len(byVersion) - 1; i >= 0; i-- {
+		at := byVersion[i].AppliedAt
+		if earliest != nil && at.After(*earliest) {
+			out[byVersion[i].Version] = struct{}{}
+		}
+		if earliest == nil || at.Before(*earliest) {
+			earliest = at
+		}
+	}
+	return out
+}
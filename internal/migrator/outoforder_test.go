@@ -0,0 +1,43 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_outOfOrder(t *testing.T) {
+	pending := []Step{{Version: 2, Name: "late"}, {Version: 5, Name: "new"}}
+	applied := map[int64]string{1: "", 3: ""}
+	late := outOfOrder(pending, applied)
+	if len(late) != 1 || late[0].Version != 2 {
+		t.Fatalf("unexpected out-of-order steps: %+v", late)
+	}
+	if got := outOfOrder(pending, map[int64]string{}); len(got) != 0 {
+		t.Fatalf("nothing is out of order on an empty database, got %+v", got)
+	}
+	msg := (&OutOfOrderError{Steps: late}).Error()
+	if !strings.Contains(msg, "2_late") || !strings.Contains(msg, "allow_out_of_order") {
+		t.Fatalf("unexpected message: %q", msg)
+	}
+}
+
+func Test_appliedOutOfOrder(t *testing.T) {
+	at := func(min int) *time.Time {
+		v := time.Date(2024, 1, 1, 0, min, 0, 0, time.UTC)
+		return &v
+	}
+	recs := []Record{
+		{Version: 1, Status: StatusApplied, AppliedAt: at(0)},
+		{Version: 2, Status: StatusApplied, AppliedAt: at(10)},
+		{Version: 3, Status: StatusApplied, AppliedAt: at(5)},
+		{Version: 4, Status: StatusApplied, AppliedAt: at(6)},
+	}
+	late := appliedOutOfOrder(recs)
+	if len(late) != 1 {
+		t.Fatalf("expected only version 2 to be out of order, got %v", late)
+	}
+	if _, ok := late[2]; !ok {
+		t.Fatalf("expected version 2 to be out of order, got %v", late)
+	}
+}
@@ -0,0 +1,80 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlannedStep is a single migration the runner would execute.
+type PlannedStep struct {
+	Version   int64
+	Name      string
+	Kind      Kind
+	Direction Direction
+	// SQL holds the statements to execute; for Go steps it is a descriptive comment.
+	SQL string
+	// NoTransaction reports that the step runs outside a transaction.
+	NoTransaction bool
+}
+
+// Plan lists migrations in the order the runner would execute them.
+type Plan struct {
+	Direction Direction
+	Steps     []PlannedStep
+}
+
+// PlanUp computes the migrations UpTo would apply without executing them.
+func (r *Runner) PlanUp(ctx context.Context, steps []Step, target int64) (Plan, error) {
+	var plan Plan
+	err := r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		pending, err := r.pendingUp(ctx, steps, target)
+		if err != nil {
+			return err
+		}
+		plan = buildPlan(pending, Up)
+		return nil
+	})
+	return plan, err
+}
+
+// PlanDown computes the migrations DownTo/DownSteps would roll back without executing them.
+// Use target -1 to limit by n only and n <= 0 to limit by target only.
+func (r *Runner) PlanDown(ctx context.Context, steps []Step, target int64, n int) (Plan, error) {
+	var plan Plan
+	err := r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		rollback, err := r.pendingDown(ctx, steps, target, n)
+		if err != nil {
+			return err
+		}
+		plan = buildPlan(rollback, Down)
+		return nil
+	})
+	return plan, err
+}
+
+// buildPlan оставляет только шаги, которые applyOne действительно выполнит.
+func buildPlan(steps []Step, dir Direction) Plan {
+	plan := Plan{Direction: dir, Steps: make([]PlannedStep, 0, len(steps))}
+	up := dir == Up
+	for _, s := range steps {
+		if s.body(up) == nil {
+			continue
+		}
+		sql := s.UpSQL
+		if !up {
+			sql = s.DownSQL
+		}
+		if s.Kind == KindGo {
+			sql = fmt.Sprintf("-- go migration %d_%s (%s function)", s.Version, s.Name, dir)
+		}
+		plan.Steps = append(plan.Steps, PlannedStep{
+			Version:       s.Version,
+			Name:          s.Name,
+			Kind:          s.Kind,
+			Direction:     dir,
+			SQL:           sql,
+			NoTransaction: s.NoTransaction,
+		})
+	}
+	return plan
+}
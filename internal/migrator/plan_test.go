@@ -0,0 +1,29 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_buildPlan(t *testing.T) {
+	steps := []Step{
+		{Version: 1, Name: "init", Kind: KindSQL, UpSQL: "CREATE TABLE a(id int);", DownSQL: "DROP TABLE a;"},
+		{Version: 2, Name: "empty", Kind: KindSQL},
+		{Version: 3, Name: "backfill", Kind: KindGo},
+	}
+	plan := buildPlan(steps, Up)
+	if plan.Direction != Up || len(plan.Steps) != 2 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if plan.Steps[0].SQL != "CREATE TABLE a(id int);" {
+		t.Fatalf("unexpected sql: %q", plan.Steps[0].SQL)
+	}
+	if plan.Steps[1].Kind != KindGo || !strings.Contains(plan.Steps[1].SQL, "3_backfill") {
+		t.Fatalf("unexpected go step: %+v", plan.Steps[1])
+	}
+
+	down := buildPlan(steps[:1], Down)
+	if down.Steps[0].SQL != "DROP TABLE a;" || down.Steps[0].Direction.String() != "down" {
+		t.Fatalf("unexpected down plan: %+v", down)
+	}
+}
@@ -0,0 +1,191 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StuckError is returned by Up when migrations are left in 'failed' or 'applying' state.
+type StuckError struct {
+	Records []Record
+}
+
+func (e *StuckError) Error() string {
+	ids := make([]string, 0, len(e.Records))
+	for _, rec := range e.Records {
+		ids = append(ids, fmt.Sprintf("%d_%s (%s)", rec.Version, rec.Name, rec.Status))
+	}
+	return fmt.Sprintf("migrations left in an unfinished state: %s; inspect the database and run `gomigrator repair`, `mark-applied <version>` or `mark-rolled-back <version>`", strings.Join(ids, ", "))
+}
+
+// stuckRecords возвращает незавершённые записи с версиями не выше target.
+func stuckRecords(recs []Record, target int64) []Record {
+	var out []Record
+	for _, rec := range recs {
+		if rec.Status != StatusApplied && rec.Version <= target {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// blocking отбрасывает failed-записи транзакционных шагов: их транзакция
+// откатилась, и Up может просто повторить миграцию.
+func blocking(stuck []Record, steps []Step) []Record {
+	tx := make(map[int64]bool, len(steps))
+	for _, s := range steps {
+		tx[s.Version] = !s.NoTransaction
+	}
+	out := stuck[:0:0]
+	for _, rec := range stuck {
+		if rec.Status == StatusFailed && tx[rec.Version] {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// Repair removes bookkeeping rows stuck in 'failed' or 'applying' state so the
+// affected migrations are retried by the next Up. It returns the removed rows.
+func (r *Runner) Repair(ctx context.Context) ([]Record, error) {
+	var stuck []Record
+	err := r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		recs, err := r.loadRecords(ctx)
+		if err != nil {
+			return err
+		}
+		stuck = stuckRecords(recs, NoTarget)
+		return r.DB.InTx(ctx, func(c Conn) error {
+			for _, rec := range stuck {
+				if err := c.DeleteRecord(ctx, rec.Version); err != nil {
+					return err
+				}
+				details := fmt.Sprintf("removed %s row", rec.Status)
+				if rec.ErrorText != nil {
+					details += ": " + *rec.ErrorText
+				}
+				if err := r.audit(ctx, c, HistoryEntry{Version: rec.Version, Action: "repair", Checksum: rec.Checksum, Details: details}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stuck, nil
+}
+
+// MarkApplied records the migration as applied without executing it.
+func (r *Runner) MarkApplied(ctx context.Context, steps []Step, version int64) error {
+	s, ok := findStep(steps, version)
+	if !ok {
+		return fmt.Errorf("migration %d not found", version)
+	}
+	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		return r.DB.InTx(ctx, func(c Conn) error {
+			return r.markApplied(ctx, c, s, "mark-applied")
+		})
+	})
+}
+
+// MarkRolledBack removes the migration's bookkeeping row without executing Down.
+func (r *Runner) MarkRolledBack(ctx context.Context, version int64) error {
+	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		return r.DB.InTx(ctx, func(c Conn) error {
+			return r.markRolledBack(ctx, c, version, "mark-rolled-back")
+		})
+	})
+}
+
+// Force rewrites the bookkeeping so that every known migration up to and including
+// version is recorded as applied and nothing newer is. No migration code is executed.
+func (r *Runner) Force(ctx context.Context, steps []Step, version int64) error {
+	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		recs, err := r.loadRecords(ctx)
+		if err != nil {
+			return err
+		}
+		mark, remove := forcePlan(steps, recs, version)
+		return r.DB.InTx(ctx, func(c Conn) error {
+			for _, s := range mark {
+				if err := r.markApplied(ctx, c, s, "force"); err != nil {
+					return err
+				}
+			}
+			for _, v := range remove {
+				if err := r.markRolledBack(ctx, c, v, "force"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// forcePlan возвращает шаги, которые нужно пометить применёнными, и версии, которые нужно удалить.
+func forcePlan(steps []Step, recs []Record, version int64) ([]Step, []int64) {
+	status := make(map[int64]MigrationStatus, len(recs))
+	var remove []int64
+	for _, rec := range recs {
+		status[rec.Version] = rec.Status
+		if rec.Version > version {
+			remove = append(remove, rec.Version)
+		}
+	}
+	var mark []Step
+	for _, s := range steps {
+		if s.Version <= version && status[s.Version] != StatusApplied {
+			mark = append(mark, s)
+		}
+	}
+	return mark, remove
+}
+
+func findStep(steps []Step, version int64) (Step, bool) {
+	for _, s := range steps {
+		if s.Version == version {
+			return s, true
+		}
+	}
+	return Step{}, false
+}
+
+func (r *Runner) markApplied(ctx context.Context, c Conn, s Step, action string) error {
+	prev, ok, err := c.GetRecord(ctx, s.Version)
+	if err != nil {
+		return err
+	}
+	if ok && prev.Status == StatusApplied {
+		return fmt.Errorf("migration %d is already applied", s.Version)
+	}
+	now := time.Now().UTC()
+	rec := Record{Version: s.Version, Name: s.Name, Checksum: s.Checksum, Status: StatusApplied, AppliedAt: &now}
+	if err := c.SaveRecord(ctx, rec); err != nil {
+		return err
+	}
+	details := "recorded as applied without execution"
+	if ok {
+		details = fmt.Sprintf("%s row recorded as applied without execution", prev.Status)
+	}
+	return r.audit(ctx, c, HistoryEntry{Version: s.Version, Action: action, Direction: "up", Checksum: s.Checksum, Details: details})
+}
+
+func (r *Runner) markRolledBack(ctx context.Context, c Conn, version int64, action string) error {
+	prev, ok, err := c.GetRecord(ctx, version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("migration %d is not recorded", version)
+	}
+	if err := c.DeleteRecord(ctx, version); err != nil {
+		return err
+	}
+	return r.audit(ctx, c, HistoryEntry{Version: version, Action: action, Direction: "down", Checksum: prev.Checksum,
+		Details: fmt.Sprintf("%s row removed without executing down", prev.Status)})
+}
@@ -0,0 +1,60 @@
+package migrator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_stuckRecords(t *testing.T) {
+	recs := []Record{
+		{Version: 1, Name: "init", Status: StatusApplied},
+		{Version: 2, Name: "index", Status: StatusFailed},
+		{Version: 3, Name: "backfill", Status: StatusApplying},
+	}
+	stuck := stuckRecords(recs, NoTarget)
+	if len(stuck) != 2 || stuck[0].Version != 2 || stuck[1].Version != 3 {
+		t.Fatalf("unexpected stuck records: %+v", stuck)
+	}
+	if stuck := stuckRecords(recs, 2); len(stuck) != 1 {
+		t.Fatalf("target must limit stuck records, got %+v", stuck)
+	}
+	msg := (&StuckError{Records: stuck}).Error()
+	if !strings.Contains(msg, "2_index (failed)") || !strings.Contains(msg, "repair") {
+		t.Fatalf("unexpected message: %q", msg)
+	}
+}
+
+func Test_blocking(t *testing.T) {
+	steps := []Step{{Version: 2}, {Version: 3, NoTransaction: true}, {Version: 4}}
+	stuck := []Record{
+		{Version: 2, Status: StatusFailed},
+		{Version: 3, Status: StatusFailed},
+		{Version: 4, Status: StatusApplying},
+		{Version: 5, Status: StatusFailed},
+	}
+	got := blocking(stuck, steps)
+	if len(got) != 3 || got[0].Version != 3 || got[1].Version != 4 || got[2].Version != 5 {
+		t.Fatalf("only failed transactional steps are retryable, got %+v", got)
+	}
+}
+
+func Test_forcePlan(t *testing.T) {
+	steps := []Step{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+	recs := []Record{
+		{Version: 1, Status: StatusApplied},
+		{Version: 2, Status: StatusFailed},
+		{Version: 4, Status: StatusApplied},
+	}
+	mark, remove := forcePlan(steps, recs, 3)
+	var marked []int64
+	for _, s := range mark {
+		marked = append(marked, s.Version)
+	}
+	if !reflect.DeepEqual(marked, []int64{2, 3}) {
+		t.Fatalf("unexpected versions to mark: %v", marked)
+	}
+	if !reflect.DeepEqual(remove, []int64{4}) {
+		t.Fatalf("unexpected versions to remove: %v", remove)
+	}
+}
@@ -0,0 +1,118 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy configures retries of migrations failing with transient errors.
+// The zero value disables retries.
+type RetryPolicy struct {
+	// Attempts is the total number of attempts per migration; values below 2 disable retries.
+	Attempts int
+	// Backoff is the delay before the second attempt, doubled for each next one (1s if zero).
+	Backoff time.Duration
+	// MaxBackoff caps the delay (30s if zero).
+	MaxBackoff time.Duration
+	// Jitter randomizes each delay by ±Jitter of its value, e.g. 0.2 for ±20%.
+	Jitter float64
+	// Retryable decides whether an error is transient; nil means IsTransient.
+	Retryable func(error) bool
+}
+
+// transientCodes — SQLSTATE ошибок конкуренции, после которых повтор безопасен.
+var transientCodes = map[string]bool{
+	"55P03": true, // lock_not_available
+	"40P01": true, // deadlock_detected
+	"40001": true, // serialization_failure
+}
+
+// IsTransient reports whether err is a PostgreSQL lock_not_available,
+// deadlock_detected or serialization_failure error.
+func IsTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && transientCodes[pgErr.Code]
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// delay возвращает паузу перед попыткой attempt+1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d, limit := p.Backoff, p.MaxBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return max(d, 0)
+}
+
+// applyWithRetry выполняет шаг и повторяет его по политике r.Retry.
+// Повторяются только транзакционные шаги: их неудачная попытка полностью откатывается.
+// Каждая неудачная попытка оставляет в истории одну запись: retry с номером попытки,
+// паузой и ошибкой, если шаг будет повторен, и failed для последней попытки.
+func (r *Runner) applyWithRetry(ctx context.Context, s Step, up bool) error {
+	for attempt := 1; ; attempt++ {
+		var wait time.Duration
+		decided, retried := false, false
+		retry := func(err error) (string, bool) {
+			decided = true
+			if s.NoTransaction || attempt >= r.Retry.Attempts || ctx.Err() != nil || !r.Retry.retryable(err) {
+				return "", false
+			}
+			wait, retried = r.Retry.delay(attempt), true
+			return fmt.Sprintf("attempt %d/%d failed, retrying in %s", attempt, r.Retry.Attempts, wait.Round(time.Millisecond)), true
+		}
+		err := r.applyOne(ctx, s, up, retry)
+		if err == nil {
+			return nil
+		}
+		if !decided {
+			// ошибка вне выполнения шага (например, при фиксации) не записана в историю
+			if details, ok := retry(err); ok {
+				r.recordRetry(ctx, s, up, details, err)
+			}
+		}
+		if !retried {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w while waiting to retry %s %d_%s: %w", ErrInterrupted, direction(up), s.Version, s.Name, context.Cause(ctx))
+		case <-stopDone(ctx):
+			t.Stop()
+			return fmt.Errorf("%w before retrying %s %d_%s: %w", ErrInterrupted, direction(up), s.Version, s.Name, stopped(ctx))
+		}
+	}
+}
+
+// recordRetry пишет в историю решение о повторе; ошибка записи не мешает повтору.
+func (r *Runner) recordRetry(ctx context.Context, s Step, up bool, details string, cause error) {
+	bg, cancel := context.WithTimeout(context.WithoutCancel(ctx), bookkeepingTimeout)
+	defer cancel()
+	e := stepEntry(s, up, "retry", 0)
+	e.Error = cause.Error()
+	e.Details = details
+	_ = r.DB.WithConn(bg, func(c Conn) error { return r.audit(bg, c, e) })
+}
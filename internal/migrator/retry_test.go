@@ -0,0 +1,40 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTransient(t *testing.T) {
+	for code, want := range map[string]bool{"55P03": true, "40P01": true, "40001": true, "23505": false, "57014": false} {
+		err := fmt.Errorf("up 1_x failed: %w", &StatementError{Index: 1, Err: &pgconn.PgError{Code: code}})
+		if got := IsTransient(err); got != want {
+			t.Errorf("IsTransient(%s) = %v; want %v", code, got, want)
+		}
+	}
+	if IsTransient(errors.New("connection refused")) {
+		t.Error("non-postgres errors are not transient")
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %s; want %s", attempt, got, want)
+		}
+	}
+	p.Jitter = 0.5
+	for range 100 {
+		if d := p.delay(2); d < time.Second || d > 3*time.Second {
+			t.Fatalf("jittered delay %s out of ±50%% range", d)
+		}
+	}
+	if d := (RetryPolicy{}).delay(1); d != time.Second {
+		t.Errorf("default backoff = %s; want 1s", d)
+	}
+}
@@ -2,281 +2,573 @@ package migrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"time"
-
-	pg "migrator/internal/driver/postgres"
 )
 
-// Runner executes SQL and Go migrations.
+// Runner executes SQL and Go migrations as a single ordered timeline.
 type Runner struct {
-	DB          *pg.DB
-	SchemaTable string
+	DB Driver
+	// AllowOutOfOrder permits applying pending migrations older than the newest applied one.
+	AllowOutOfOrder bool
+	// StatementTimeout and LockTimeout are applied to every migration unless its
+	// file overrides them; zero leaves the database settings untouched.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// Retry re-runs transactional migrations failing with transient errors; off by default.
+	Retry RetryPolicy
+	// Run identifies this process in the history table.
+	Run RunInfo
+	// SchemaFile receives a schema snapshot after every successful up, down or redo.
+	SchemaFile string
+	// AllowMissingTables treats a missing schema table as "nothing applied" when the
+	// driver implements TableChecker; set it for dry runs on a driver opened without EnsureTables.
+	AllowMissingTables bool
 }
 
 // NewRunner creates a new Runner instance.
-func NewRunner(db *pg.DB) *Runner { return &Runner{DB: db, SchemaTable: db.SchemaTable} }
+func NewRunner(db Driver) *Runner { return &Runner{DB: db, Run: NewRunInfo()} }
+
+// NoTarget means "no version limit" for UpTo and DownTo.
+const NoTarget int64 = math.MaxInt64
 
-// Up applies all pending SQL migrations found in the directory.
+// Up applies all pending migrations in version order.
 func (r *Runner) Up(ctx context.Context, steps []Step) error {
-	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
-		applied, err := r.loadApplied(ctx)
-		if err != nil {
-			return err
-		}
-		// filter pending
-		pending := make([]Step, 0)
-		for _, s := range steps {
-			if _, ok := applied[s.Version]; !ok {
-				pending = append(pending, s)
-			}
-		}
-		sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
-		for _, s := range pending {
-			if err := r.applyOne(ctx, s, true); err != nil {
-				return err
-			}
-		}
-		return nil
+	return r.UpTo(ctx, steps, NoTarget)
+}
+
+// UpTo applies pending migrations with versions up to and including target.
+func (r *Runner) UpTo(ctx context.Context, steps []Step, target int64) error {
+	return r.migrate(ctx, func(ctx context.Context) error {
+		return r.up(ctx, steps, target)
 	})
 }
 
 // Down rolls back the last applied migration.
 func (r *Runner) Down(ctx context.Context, steps []Step) error {
-	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
-		applied, err := r.loadApplied(ctx)
-		if err != nil {
-			return err
-		}
-		var lastVer int64 = -1
-		for v := range applied {
-			if v > lastVer {
-				lastVer = v
-			}
-		}
-		if lastVer < 0 {
-			return nil
-		}
-		// find step by version
-		var last Step
-		found := false
-		for _, s := range steps {
-			if s.Version == lastVer {
-				last = s
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("cannot find migration %d to rollback", lastVer)
-		}
-		return r.applyOne(ctx, last, false)
+	return r.DownSteps(ctx, steps, 1)
+}
+
+// DownTo rolls back all applied migrations with versions greater than target.
+func (r *Runner) DownTo(ctx context.Context, steps []Step, target int64) error {
+	return r.migrate(ctx, func(ctx context.Context) error {
+		return r.down(ctx, steps, target, 0)
+	})
+}
+
+// DownSteps rolls back the n most recently applied migrations.
+func (r *Runner) DownSteps(ctx context.Context, steps []Step, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("steps must be positive, got %d", n)
+	}
+	return r.migrate(ctx, func(ctx context.Context) error {
+		return r.down(ctx, steps, -1, n)
 	})
 }
 
 // Redo rolls back and then reapplies the last migration.
 func (r *Runner) Redo(ctx context.Context, steps []Step) error {
-	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
-		if err := r.Down(ctx, steps); err != nil {
+	return r.migrate(ctx, func(ctx context.Context) error {
+		if err := r.down(ctx, steps, -1, 1); err != nil {
 			return err
 		}
-		return r.Up(ctx, steps)
+		return r.up(ctx, steps, NoTarget)
 	})
 }
 
-// UpGo applies all pending Go migrations.
-func (r *Runner) UpGo(ctx context.Context, steps []GoStep) error {
+// migrate выполняет прогон под блокировкой и, если он успешен, там же сохраняет
+// снимок схемы, чтобы он соответствовал только что примененным миграциям.
+func (r *Runner) migrate(ctx context.Context, fn func(context.Context) error) error {
 	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
-		applied, err := r.loadApplied(ctx)
-		if err != nil {
+		if err := fn(ctx); err != nil {
 			return err
 		}
-		sort.Slice(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
-		for _, s := range steps {
-			if _, ok := applied[s.Version]; ok {
-				continue
-			}
-			if err := r.applyGo(ctx, s, true); err != nil {
-				return err
-			}
+		// миграции уже зафиксированы: повторять прогон не нужно, только снимок
+		if err := r.writeSchema(ctx); err != nil {
+			return fmt.Errorf("migrations were applied, but the schema file %s was not updated: %w", r.SchemaFile, err)
 		}
 		return nil
 	})
 }
 
-// DownGo rolls back the last applied Go migration.
-func (r *Runner) DownGo(ctx context.Context, steps []GoStep) error {
-	return r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
-		applied, err := r.loadApplied(ctx)
-		if err != nil {
-			return err
+func (r *Runner) up(ctx context.Context, steps []Step, target int64) error {
+	pending, err := r.pendingUp(ctx, steps, target)
+	if err != nil {
+		return err
+	}
+	return r.applyAll(ctx, pending, true)
+}
+
+func (r *Runner) down(ctx context.Context, steps []Step, target int64, n int) error {
+	rollback, err := r.pendingDown(ctx, steps, target, n)
+	if err != nil {
+		return err
+	}
+	return r.applyAll(ctx, rollback, false)
+}
+
+// ErrInterrupted is returned (wrapped) when the context is cancelled during a run.
+// Migrations completed before the cancellation stay applied.
+var ErrInterrupted = errors.New("interrupted")
+
+type stopKey struct{}
+
+// WithStop returns a context that asks a run to stop before the next migration once
+// stop is done. Unlike cancelling ctx itself, the in-flight migration is allowed to finish.
+func WithStop(ctx, stop context.Context) context.Context {
+	return context.WithValue(ctx, stopKey{}, stop)
+}
+
+// stopped сообщает, нужно ли остановиться перед следующей миграцией.
+func stopped(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if stop, ok := ctx.Value(stopKey{}).(context.Context); ok && stop.Err() != nil {
+		return context.Cause(stop)
+	}
+	return nil
+}
+
+// stopDone возвращает канал запроса остановки или nil, если он не задан.
+func stopDone(ctx context.Context) <-chan struct{} {
+	if stop, ok := ctx.Value(stopKey{}).(context.Context); ok {
+		return stop.Done()
+	}
+	return nil
+}
+
+// applyAll выполняет шаги по порядку и проверяет отмену перед каждым.
+func (r *Runner) applyAll(ctx context.Context, steps []Step, up bool) error {
+	for _, s := range steps {
+		if cause := stopped(ctx); cause != nil {
+			return fmt.Errorf("%w before %s %d_%s: %w", ErrInterrupted, direction(up), s.Version, s.Name, cause)
 		}
-		var lastVer int64 = -1
-		for v := range applied {
-			if v > lastVer {
-				lastVer = v
+		if err := r.applyWithRetry(ctx, s, up); err != nil {
+			if ctx.Err() == nil || errors.Is(err, ErrInterrupted) {
+				return err
 			}
-		}
-		if lastVer < 0 {
-			return nil
-		}
-		sort.Slice(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
-		for _, s := range steps {
-			if s.Version == lastVer {
-				return r.applyGo(ctx, s, false)
+			state := "its transaction was rolled back"
+			if s.NoTransaction {
+				state = "it is left in 'failed' state, see `gomigrator repair`"
 			}
+			return fmt.Errorf("%w during %s %d_%s, %s: %w", ErrInterrupted, direction(up), s.Version, s.Name, state, err)
 		}
-		return fmt.Errorf("cannot find go migration %d to rollback", lastVer)
-	})
+	}
+	return nil
 }
 
-func (r *Runner) applyGo(ctx context.Context, s GoStep, up bool) error {
-	tx, err := r.DB.Pool.Begin(ctx)
+func direction(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+// pendingUp возвращает шаги для применения в порядке выполнения.
+func (r *Runner) pendingUp(ctx context.Context, steps []Step, target int64) ([]Step, error) {
+	recs, err := r.loadRecords(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	started := time.Now()
-	if up {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s(version,name,checksum,status,updated_at) VALUES($1,$2,$3,'applying',now())", r.SchemaTable), s.Version, s.Name, "go://checksum"); err != nil {
-			_ = tx.Rollback(ctx)
-			return err
-		}
-		if err := s.Up(tx); err != nil {
-			_, _ = tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='failed', updated_at=now(), error_text=$2 WHERE version=$1", r.SchemaTable), s.Version, err.Error())
-			_ = tx.Rollback(ctx)
-			return fmt.Errorf("up %d_%s failed: %w", s.Version, s.Name, err)
-		}
-		dur := time.Since(started)
-		if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='applied', applied_at=now(), updated_at=now(), execution_ms=$2, error_text=NULL WHERE version=$1", r.SchemaTable), s.Version, dur.Milliseconds()); err != nil {
-			_ = tx.Rollback(ctx)
-			return err
-		}
-	} else {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='applying', updated_at=now() WHERE version=$1", r.SchemaTable), s.Version); err != nil {
-			_ = tx.Rollback(ctx)
-			return err
+	applied := appliedChecksums(recs)
+	if drifts := knownDrift(detectDrift(steps, applied)); len(drifts) > 0 {
+		return nil, &DriftError{Drifts: drifts}
+	}
+	stuck := stuckRecords(recs, target)
+	if dc, ok := r.DB.(DDLCommitter); !ok || dc.TransactionalDDL() {
+		stuck = blocking(stuck, steps)
+	}
+	if len(stuck) > 0 {
+		return nil, &StuckError{Records: stuck}
+	}
+	// filter pending
+	pending := make([]Step, 0)
+	for _, s := range steps {
+		if _, ok := applied[s.Version]; !ok && s.Version <= target {
+			pending = append(pending, s)
 		}
-		if s.Down != nil {
-			if err := s.Down(tx); err != nil {
-				_, _ = tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='failed', updated_at=now(), error_text=$2 WHERE version=$1", r.SchemaTable), s.Version, err.Error())
-				_ = tx.Rollback(ctx)
-				return fmt.Errorf("down %d_%s failed: %w", s.Version, s.Name, err)
-			}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	if late := outOfOrder(pending, applied); len(late) > 0 && !r.AllowOutOfOrder {
+		return nil, &OutOfOrderError{Steps: late}
+	}
+	return pending, nil
+}
+
+// pendingDown возвращает шаги для отката в порядке выполнения.
+func (r *Runner) pendingDown(ctx context.Context, steps []Step, target int64, n int) ([]Step, error) {
+	applied, err := r.loadApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]Step, len(steps))
+	for _, s := range steps {
+		byVersion[s.Version] = s
+	}
+	// найти все шаги до начала отката, чтобы не остановиться на середине
+	versions := rollbackVersions(applied, target, n)
+	rollback := make([]Step, 0, len(versions))
+	for _, v := range versions {
+		s, ok := byVersion[v]
+		if !ok {
+			return nil, fmt.Errorf("cannot find migration %d to rollback", v)
 		}
-		if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=$1", r.SchemaTable), s.Version); err != nil {
-			_ = tx.Rollback(ctx)
-			return err
+		rollback = append(rollback, s)
+	}
+	return rollback, nil
+}
+
+// rollbackVersions возвращает версии для отката от новых к старым: все версии
+// больше target и не более n штук (n <= 0 — без ограничения).
+func rollbackVersions(applied map[int64]string, target int64, n int) []int64 {
+	out := make([]int64, 0, len(applied))
+	for v := range applied {
+		if v > target {
+			out = append(out, v)
 		}
 	}
-	return tx.Commit(ctx)
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
 }
 
 // DBVersion returns the current database migration version.
 func (r *Runner) DBVersion(ctx context.Context) (int64, error) {
-	rows, err := r.DB.Pool.Query(ctx, fmt.Sprintf("SELECT version FROM %s WHERE status='applied' ORDER BY version DESC LIMIT 1", r.SchemaTable))
+	recs, err := r.loadRecords(ctx)
 	if err != nil {
 		return 0, err
 	}
-	defer rows.Close()
-	if rows.Next() {
-		var v int64
-		if err := rows.Scan(&v); err != nil {
-			return 0, err
+	var v int64
+	for _, rec := range recs {
+		if rec.Status == StatusApplied && rec.Version > v {
+			v = rec.Version
 		}
-		return v, nil
 	}
-	return 0, nil
-}
-
-// StatusRow represents a single row in the migration status table.
-type StatusRow struct {
-	Version   int64
-	Name      string
-	Status    string
-	UpdatedAt time.Time
+	return v, nil
 }
 
-// Status returns the migration status for all migrations.
-func (r *Runner) Status(ctx context.Context) ([]StatusRow, error) {
-	q := fmt.Sprintf("SELECT version,name,status,updated_at FROM %s ORDER BY version", r.SchemaTable)
-	rows, err := r.DB.Pool.Query(ctx, q)
+// loadApplied возвращает контрольные суммы применённых миграций по версиям.
+func (r *Runner) loadApplied(ctx context.Context) (map[int64]string, error) {
+	recs, err := r.loadRecords(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	res := []StatusRow{}
-	for rows.Next() {
-		var s StatusRow
-		if err := rows.Scan(&s.Version, &s.Name, &s.Status, &s.UpdatedAt); err != nil {
+	return appliedChecksums(recs), nil
+}
+
+// loadRecords читает все строки таблицы схемы, включая failed и applying.
+func (r *Runner) loadRecords(ctx context.Context) ([]Record, error) {
+	if tc, ok := r.DB.(TableChecker); ok && r.AllowMissingTables {
+		exists, err := tc.SchemaTableExists(ctx)
+		if err != nil || !exists {
 			return nil, err
 		}
-		res = append(res, s)
 	}
-	return res, rows.Err()
+	return r.DB.LoadRecords(ctx)
 }
 
-func (r *Runner) loadApplied(ctx context.Context) (map[int64]struct{}, error) {
-	rows, err := r.DB.Pool.Query(ctx, fmt.Sprintf("SELECT version FROM %s WHERE status='applied'", r.SchemaTable))
-	if err != nil {
-		return nil, err
+func appliedChecksums(recs []Record) map[int64]string {
+	m := map[int64]string{}
+	for _, rec := range recs {
+		if rec.Status == StatusApplied {
+			m[rec.Version] = rec.Checksum
+		}
 	}
-	defer rows.Close()
-	m := map[int64]struct{}{}
-	for rows.Next() {
-		var v int64
-		if err := rows.Scan(&v); err != nil {
-			return nil, err
+	return m
+}
+
+// applyOne выполняет шаг один раз. retry решает, будет ли неудачная попытка
+// повторена, и возвращает детали записи retry, которая тогда заменяет failed.
+func (r *Runner) applyOne(ctx context.Context, s Step, up bool, retry func(error) (string, bool)) error {
+	action := direction(up)
+	run := s.body(up)
+	if run == nil {
+		return nil
+	}
+	if s.NoTransaction {
+		return r.applyNoTx(ctx, s, up, run)
+	}
+	var failed error
+	started := time.Now()
+	err := r.DB.InTx(ctx, func(c Conn) error {
+		// пометить как выполняемую
+		rec, err := markApplying(ctx, c, s, up)
+		if err != nil {
+			return err
+		}
+		if err := r.setTimeouts(ctx, c, s); err != nil {
+			failed = err
+			return fmt.Errorf("%s %d_%s: %w", action, s.Version, s.Name, err)
+		}
+		if err := run(ctx, c); err != nil {
+			failed = err
+			return fmt.Errorf("%s %d_%s failed: %w", action, s.Version, s.Name, err)
+		}
+		return r.finish(ctx, c, s, rec, up, time.Since(started))
+	})
+	if failed != nil {
+		// транзакция откатилась вместе со статусом: сохраняем ошибку (например,
+		// таймаут) отдельным запросом; failed-запись up не блокирует повторный Up,
+		// а запись down не трогаем — миграция остается примененной
+		var rec *Record
+		if up {
+			rec = &Record{Version: s.Version, Name: s.Name, Checksum: s.Checksum}
 		}
-		m[v] = struct{}{}
+		e := stepEntry(s, up, "failed", time.Since(started))
+		if details, ok := retry(failed); ok {
+			e.Action, e.Details = "retry", details
+		}
+		r.recordFailure(ctx, nil, rec, e, failed)
 	}
-	return m, rows.Err()
+	return err
 }
 
-func (r *Runner) applyOne(ctx context.Context, s Step, up bool) error {
-	sql := s.UpSQL
-	action := "up"
+// finish завершает шаг и пишет в журнал applied или rolled-back.
+func (r *Runner) finish(ctx context.Context, c Conn, s Step, rec Record, up bool, dur time.Duration) error {
+	if err := markDone(ctx, c, rec, up, dur); err != nil {
+		return err
+	}
+	action := "applied"
 	if !up {
-		sql = s.DownSQL
-		action = "down"
+		action = "rolled-back"
 	}
-	if strings.TrimSpace(sql) == "" {
+	return r.audit(ctx, c, stepEntry(s, up, action, dur))
+}
+
+// setTimeouts выставляет таймауты шага (или значения по умолчанию) на соединении.
+// Таймауты из файла на драйвере без их поддержки — ошибка, умолчания игнорируются.
+func (r *Runner) setTimeouts(ctx context.Context, c Conn, s Step) error {
+	statement, lock := pickTimeout(s.StatementTimeout, r.StatementTimeout), pickTimeout(s.LockTimeout, r.LockTimeout)
+	if statement == 0 && lock == 0 {
 		return nil
 	}
-	tx, err := r.DB.Pool.Begin(ctx)
-	if err != nil {
-		return err
+	ts, ok := c.(TimeoutSetter)
+	if !ok {
+		if s.StatementTimeout != 0 || s.LockTimeout != 0 {
+			return errors.New("statement_timeout and lock_timeout directives require the postgres driver")
+		}
+		return nil
 	}
-	started := time.Now()
-	// пометить как выполняемую
-	if up {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s(version,name,checksum,status,updated_at) VALUES($1,$2,$3,'applying',now())", r.SchemaTable), s.Version, s.Name, s.Checksum); err != nil {
-			_ = tx.Rollback(ctx)
+	return ts.SetTimeouts(ctx, statement, lock)
+}
+
+func pickTimeout(step, def time.Duration) time.Duration {
+	if step != 0 {
+		return step
+	}
+	return def
+}
+
+// applyNoTx выполняет шаг вне транзакции. Учёт в таблице схемы
+// (applying → applied/failed) ведётся отдельными короткими запросами,
+// чтобы состояние сбоя сохранялось даже после частичного выполнения.
+func (r *Runner) applyNoTx(ctx context.Context, s Step, up bool, run func(context.Context, Conn) error) error {
+	action := direction(up)
+	if s.Kind == KindSQL {
+		// ошибки разбора сообщаем до записи статуса applying
+		if _, err := s.statements(up, syntaxOf(r.DB)); err != nil {
+			return fmt.Errorf("%s %d_%s: %w", action, s.Version, s.Name, err)
+		}
+	}
+	if s.Kind == KindGo {
+		return r.applyPool(ctx, s, up, run)
+	}
+	// одно соединение на все выражения, чтобы сохранялись сессионные SET
+	return r.DB.WithConn(ctx, func(c Conn) error {
+		started := time.Now()
+		rec, err := markApplying(ctx, c, s, up)
+		if err != nil {
 			return err
 		}
-	} else {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='applying', updated_at=now() WHERE version=$1", r.SchemaTable), s.Version); err != nil {
-			_ = tx.Rollback(ctx)
+		if err := r.setTimeouts(ctx, c, s); err != nil {
+			err = fmt.Errorf("%s %d_%s: %w", action, s.Version, s.Name, err)
+			r.recordFailure(ctx, c, &rec, stepEntry(s, up, "failed", time.Since(started)), err)
 			return err
 		}
+		if err := run(ctx, c); err != nil {
+			// после отмены контекста соединение может быть закрыто драйвером:
+			// тогда статус failed пишется через новое соединение без отмены
+			r.recordFailure(ctx, c, &rec, stepEntry(s, up, "failed", time.Since(started)), err)
+			return fmt.Errorf("%s %d_%s failed: %w", action, s.Version, s.Name, err)
+		}
+		return r.finish(ctx, c, s, rec, up, time.Since(started))
+	})
+}
+
+// applyPool выполняет Go-миграцию с пулом. Функция сама берет соединения из пула,
+// поэтому соединение мигратора на время ее работы не удерживается: вместе с
+// соединением блокировки это заняло бы два соединения, и пул из двух встал бы.
+// Статусы пишутся отдельными короткими соединениями. Таймауты шага сюда не
+// доходят: пул общий, а SET на одном из его соединений ничего не ограничит.
+func (r *Runner) applyPool(ctx context.Context, s Step, up bool, run func(context.Context, Conn) error) error {
+	action := direction(up)
+	started := time.Now()
+	var (
+		rec  Record
+		pool Conn
+	)
+	err := r.DB.WithConn(ctx, func(c Conn) error {
+		if pc, ok := c.(PgxConn); !ok || pc.PgxPool() == nil {
+			return fmt.Errorf("%s %d_%s: %w", action, s.Version, s.Name, errNoPgx)
+		}
+		var err error
+		rec, err = markApplying(ctx, c, s, up)
+		// из соединения body берет только пул, который переживает возврат соединения
+		pool = c
+		return err
+	})
+	if err != nil {
+		return err
 	}
-	if _, err := tx.Exec(ctx, sql); err != nil {
-		_, _ = tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='failed', updated_at=now(), error_text=$2 WHERE version=$1", r.SchemaTable), s.Version, err.Error())
-		_ = tx.Rollback(ctx)
+	if err := run(ctx, pool); err != nil {
+		r.recordFailure(ctx, nil, &rec, stepEntry(s, up, "failed", time.Since(started)), err)
 		return fmt.Errorf("%s %d_%s failed: %w", action, s.Version, s.Name, err)
 	}
-	dur := time.Since(started)
-	if up {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET status='applied', applied_at=now(), updated_at=now(), execution_ms=$2, error_text=NULL WHERE version=$1", r.SchemaTable), s.Version, dur.Milliseconds()); err != nil {
-			_ = tx.Rollback(ctx)
-			return err
+	return r.DB.WithConn(ctx, func(c Conn) error {
+		return r.finish(ctx, c, s, rec, up, time.Since(started))
+	})
+}
+
+// bookkeepingTimeout ограничивает запись статуса после отмены контекста.
+const bookkeepingTimeout = 10 * time.Second
+
+// markApplying записывает статус applying и возвращает запись шага.
+func markApplying(ctx context.Context, c Conn, s Step, up bool) (Record, error) {
+	rec := Record{Version: s.Version, Name: s.Name, Checksum: s.Checksum}
+	if !up {
+		existing, ok, err := c.GetRecord(ctx, s.Version)
+		if err != nil {
+			return Record{}, err
+		}
+		if ok {
+			rec = existing
 		}
-	} else {
-		if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE version=$1", r.SchemaTable), s.Version); err != nil {
-			_ = tx.Rollback(ctx)
+	}
+	rec.Status = StatusApplying
+	return rec, c.SaveRecord(ctx, rec)
+}
+
+// markFailed записывает статус failed с текстом ошибки.
+func markFailed(ctx context.Context, c Conn, rec Record, cause error) error {
+	msg := cause.Error()
+	rec.Status = StatusFailed
+	rec.ErrorText = &msg
+	return c.SaveRecord(ctx, rec)
+}
+
+// markDone завершает шаг: после up запись становится applied, после down удаляется.
+func markDone(ctx context.Context, c Conn, rec Record, up bool, dur time.Duration) error {
+	if !up {
+		return c.DeleteRecord(ctx, rec.Version)
+	}
+	now := time.Now().UTC()
+	rec.Status = StatusApplied
+	rec.AppliedAt = &now
+	rec.ExecutionMs = dur.Milliseconds()
+	rec.ErrorText = nil
+	return c.SaveRecord(ctx, rec)
+}
+
+// errNoPgx возвращается, если Go-миграцию пытаются выполнить не на PostgreSQL.
+var errNoPgx = errors.New("go migrations require the postgres driver")
+
+// body возвращает функцию, выполняющую шаг, или nil, если выполнять нечего.
+// SQL-шаг с пустой секцией пропускается; Go-шаг без функции только обновляет таблицу схемы.
+func (s Step) body(up bool) func(context.Context, Conn) error {
+	if s.Kind == KindGo && s.NoTransaction {
+		fn := s.UpPoolFn
+		if !up {
+			fn = s.DownPoolFn
+		}
+		return func(ctx context.Context, c Conn) error {
+			if fn == nil {
+				return nil
+			}
+			pc, ok := c.(PgxConn)
+			if !ok || pc.PgxPool() == nil {
+				return errNoPgx
+			}
+			return fn(ctx, pc.PgxPool())
+		}
+	}
+	if s.Kind == KindGo {
+		fn := s.UpFn
+		if !up {
+			fn = s.DownFn
+		}
+		return func(ctx context.Context, c Conn) error {
+			if fn == nil {
+				return nil
+			}
+			pc, ok := c.(PgxConn)
+			if !ok || pc.PgxTx() == nil {
+				return errNoPgx
+			}
+			return fn(ctx, pc.PgxTx())
+		}
+	}
+	sql := s.UpSQL
+	if !up {
+		sql = s.DownSQL
+	}
+	if strings.TrimSpace(sql) == "" {
+		return nil
+	}
+	return func(ctx context.Context, c Conn) error {
+		stmts, err := s.statements(up, syntaxOf(c))
+		if err != nil {
 			return err
 		}
+		return execStatements(ctx, c, stmts)
+	}
+}
+
+// statements делит секцию на выражения по правилам syntax. У шагов из файлов
+// номера строк отсчитываются от начала файла, у остальных — от начала секции.
+func (s Step) statements(up bool, syntax Syntax) ([]Statement, error) {
+	src, sql := s.upSource, s.UpSQL
+	if !up {
+		src, sql = s.downSource, s.DownSQL
+	}
+	if src.Text == "" {
+		return SplitStatementsSyntax(sql, syntax)
+	}
+	stmts, err := SplitStatementsSyntax(src.Text, syntax)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stmts {
+		stmts[i].Line += src.Offset
+	}
+	return stmts, nil
+}
+
+// StatementError reports which statement of a migration failed.
+type StatementError struct {
+	Index int
+	Line  int
+	Err   error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %d (line %d): %v", e.Index, e.Line, e.Err)
+}
+
+func (e *StatementError) Unwrap() error { return e.Err }
+
+// execStatements выполняет выражения по одному, останавливаясь на первой ошибке.
+func execStatements(ctx context.Context, c Conn, stmts []Statement) error {
+	for i, st := range stmts {
+		if err := c.Exec(ctx, st.SQL); err != nil {
+			return &StatementError{Index: i + 1, Line: st.Line, Err: err}
+		}
 	}
-	return tx.Commit(ctx)
+	return nil
 }
@@ -0,0 +1,31 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_rollbackVersions(t *testing.T) {
+	applied := map[int64]string{1: "", 2: "", 3: "", 4: ""}
+	tests := []struct {
+		name   string
+		target int64
+		n      int
+		want   []int64
+	}{
+		{"last one", -1, 1, []int64{4}},
+		{"two steps", -1, 2, []int64{4, 3}},
+		{"to version", 2, 0, []int64{4, 3}},
+		{"to zero", 0, 0, []int64{4, 3, 2, 1}},
+		{"nothing above target", 4, 0, []int64{}},
+		{"more steps than applied", -1, 10, []int64{4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rollbackVersions(applied, tt.target, tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("rollbackVersions(%d, %d) = %v; want %v", tt.target, tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemaSnapshotter is implemented by drivers that can describe the database
+// schema as normalised DDL, one statement per returned string.
+type SchemaSnapshotter interface {
+	SchemaSnapshot(ctx context.Context) ([]string, error)
+}
+
+// errNoSnapshot возвращается, если драйвер не умеет строить снимок схемы.
+var errNoSnapshot = errors.New("schema snapshots require the postgres driver")
+
+const (
+	snapshotTitle   = "-- Schema snapshot generated by gomigrator from pg_catalog. Do not edit."
+	snapshotVersion = "-- Migration version: "
+)
+
+// SchemaSnapshot returns the schema snapshot of the database: a header with the
+// current migration version followed by DDL statements separated by blank lines.
+func (r *Runner) SchemaSnapshot(ctx context.Context) (string, error) {
+	ss, ok := r.DB.(SchemaSnapshotter)
+	if !ok {
+		return "", errNoSnapshot
+	}
+	v, err := r.DBVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	stmts, err := ss.SchemaSnapshot(ctx)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s%d\n", snapshotTitle, snapshotVersion, v)
+	for _, s := range stmts {
+		b.WriteString("\n")
+		b.WriteString(strings.TrimSpace(s))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// writeSchema сохраняет снимок схемы в r.SchemaFile, если он задан.
+func (r *Runner) writeSchema(ctx context.Context) error {
+	if r.SchemaFile == "" {
+		return nil
+	}
+	snap, err := r.SchemaSnapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("schema snapshot: %w", err)
+	}
+	if err := WriteSchemaFile(r.SchemaFile, snap); err != nil {
+		return fmt.Errorf("schema snapshot: %w", err)
+	}
+	return nil
+}
+
+// WriteSchemaFile writes a schema snapshot to path through a temporary file that
+// is synced to disk and renamed into place, so neither readers nor a crash leave
+// a truncated snapshot.
+func WriteSchemaFile(path, snap string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(snap)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// ScratchDatabaser is implemented by drivers that can create a throwaway database
+// on the same server. drop removes it and must be called once the driver is no longer used.
+type ScratchDatabaser interface {
+	ScratchDatabase(ctx context.Context, name string) (db Driver, drop func(), err error)
+}
+
+// Diff applies exactly the migrations recorded as applied in the database to a
+// throwaway database on the same server and compares its schema with the live
+// one. The reference side of the result is the migrations: Missing objects are
+// defined by migrations but absent from the database, Unexpected ones exist only
+// in the database.
+func (r *Runner) Diff(ctx context.Context, steps []Step) (SchemaDrift, error) {
+	sd, ok := r.DB.(ScratchDatabaser)
+	if !ok {
+		return SchemaDrift{}, errors.New("diff requires the postgres driver")
+	}
+	recs, err := r.loadRecords(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	// пропущенные старые миграции в рабочей БД не применены, значит и во
+	// временной их быть не должно: берем ровно версии со статусом applied
+	applied := appliedChecksums(recs)
+	var subset []Step
+	for _, s := range steps {
+		if _, ok := applied[s.Version]; ok {
+			subset = append(subset, s)
+			delete(applied, s.Version)
+		}
+	}
+	if len(applied) > 0 {
+		missing := make([]int64, 0, len(applied))
+		for v := range applied {
+			missing = append(missing, v)
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+		return SchemaDrift{}, fmt.Errorf("applied migrations not found in the source: %v", missing)
+	}
+	db, drop, err := sd.ScratchDatabase(ctx, "gomigrator_diff_"+r.Run.ID)
+	if err != nil {
+		return SchemaDrift{}, fmt.Errorf("create scratch database: %w", err)
+	}
+	defer drop()
+	scratch := &Runner{DB: db, AllowOutOfOrder: true, Run: r.Run}
+	if err := scratch.Up(ctx, subset); err != nil {
+		return SchemaDrift{}, fmt.Errorf("apply migrations to scratch database: %w", err)
+	}
+	want, err := scratch.SchemaSnapshot(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	got, err := r.SchemaSnapshot(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	return DiffSchema(want, got), nil
+}
+
+// SchemaDrift is the difference between a reference schema (a schema file or
+// the migrations) and the live database.
+type SchemaDrift struct {
+	// FileVersion and DBVersion are the migration versions of the file and the database.
+	FileVersion int64
+	DBVersion   int64
+	// Missing lists statements of the file absent from the database.
+	Missing []string
+	// Unexpected lists statements of the database absent from the file.
+	Unexpected []string
+	// Changed lists objects present on both sides with different definitions,
+	// such as a table with an extra column.
+	Changed []SchemaChange
+}
+
+// SchemaChange is a line-level difference of one object's definition.
+type SchemaChange struct {
+	// Object is the first line of the definition, e.g. "CREATE TABLE public.users (".
+	Object string
+	// Removed lines exist only in the reference, Added lines only in the database.
+	Removed []string
+	Added   []string
+}
+
+// Empty reports whether the schemas match.
+func (d SchemaDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0 && len(d.Changed) == 0
+}
+
+// CheckSchema compares the schema file with a fresh snapshot of the database.
+func (r *Runner) CheckSchema(ctx context.Context, file string) (SchemaDrift, error) {
+	want, err := os.ReadFile(file)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	got, err := r.SchemaSnapshot(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	return DiffSchema(string(want), got), nil
+}
+
+// DiffSchema compares two snapshots statement by statement, ignoring order and headers.
+func DiffSchema(file, db string) SchemaDrift {
+	fv, fileStmts := parseSnapshot(file)
+	dv, dbStmts := parseSnapshot(db)
+	d := SchemaDrift{FileVersion: fv, DBVersion: dv}
+	d.Missing, d.Unexpected, d.Changed = pairChanges(subtract(fileStmts, dbStmts), subtract(dbStmts, fileStmts))
+	return d
+}
+
+// pairChanges сопоставляет блоки с одинаковой первой строкой (одна и та же таблица,
+// функция и т.п.) и заменяет их построчной разницей; остальные блоки возвращает как есть.
+func pairChanges(missing, unexpected []string) (restMissing, restUnexpected []string, changed []SchemaChange) {
+	used := make([]bool, len(unexpected))
+	for _, m := range missing {
+		head, _, _ := strings.Cut(m, "\n")
+		j := -1
+		for i, u := range unexpected {
+			if uh, _, _ := strings.Cut(u, "\n"); !used[i] && uh == head {
+				j = i
+				break
+			}
+		}
+		if j < 0 {
+			restMissing = append(restMissing, m)
+			continue
+		}
+		used[j] = true
+		ml, ul := blockLines(m), blockLines(unexpected[j])
+		changed = append(changed, SchemaChange{Object: head, Removed: subtract(ml, ul), Added: subtract(ul, ml)})
+	}
+	for i, u := range unexpected {
+		if !used[i] {
+			restUnexpected = append(restUnexpected, u)
+		}
+	}
+	return restMissing, restUnexpected, changed
+}
+
+// blockLines возвращает строки определения без первой; завершающие запятые
+// отбрасываются, чтобы добавление колонки в конец не меняло предыдущую строку.
+func blockLines(block string) []string {
+	lines := strings.Split(block, "\n")[1:]
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		out = append(out, strings.TrimSuffix(strings.TrimSpace(l), ","))
+	}
+	return out
+}
+
+// parseSnapshot делит снимок на версию из заголовка и блоки, разделенные пустыми строками.
+// Заголовок — комментарии в начале файла до первой пустой строки.
+func parseSnapshot(s string) (int64, []string) {
+	var version int64
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	head, body, _ := strings.Cut(s, "\n\n")
+	for _, line := range strings.Split(head, "\n") {
+		if !strings.HasPrefix(line, "--") {
+			// заголовка нет — весь текст является телом
+			body = s
+			break
+		}
+		if v, ok := strings.CutPrefix(line, snapshotVersion); ok {
+			version, _ = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+	}
+	var out []string
+	for _, block := range strings.Split(body, "\n\n") {
+		if block = strings.TrimSpace(block); block != "" {
+			out = append(out, block)
+		}
+	}
+	return version, out
+}
+
+// subtract возвращает блоки a, которых нет в b, с учетом повторов.
+func subtract(a, b []string) []string {
+	have := make(map[string]int, len(b))
+	for _, s := range b {
+		have[s]++
+	}
+	var out []string
+	for _, s := range a {
+		if have[s] > 0 {
+			have[s]--
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSchema(t *testing.T) {
+	file := snapshotTitle + "\n" + snapshotVersion + "2\n\n" +
+		"CREATE TABLE public.a (\n    id integer NOT NULL\n);\n\n" +
+		"CREATE TABLE public.b (\n    id integer\n);\n\n" +
+		"CREATE INDEX b_id_idx ON public.b USING btree (id);\n"
+	db := snapshotTitle + "\n" + snapshotVersion + "3\n\n" +
+		"CREATE TABLE public.b (\n    id integer\n);\n\n" +
+		"CREATE TABLE public.a (\n    id integer NOT NULL,\n    note text\n);\n"
+	d := DiffSchema(file, db)
+	if d.FileVersion != 2 || d.DBVersion != 3 {
+		t.Fatalf("unexpected versions: %+v", d)
+	}
+	if want := []string{"CREATE INDEX b_id_idx ON public.b USING btree (id);"}; !reflect.DeepEqual(d.Missing, want) {
+		t.Fatalf("missing = %q; want %q", d.Missing, want)
+	}
+	if len(d.Unexpected) != 0 {
+		t.Fatalf("unexpected = %q", d.Unexpected)
+	}
+	// таблица есть с обеих сторон: различие сводится к добавленной колонке
+	want := []SchemaChange{{Object: "CREATE TABLE public.a (", Added: []string{"note text"}}}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Fatalf("changed = %+v; want %+v", d.Changed, want)
+	}
+	if d := DiffSchema(file, file); !d.Empty() {
+		t.Fatalf("identical snapshots must not drift: %+v", d)
+	}
+	// файл без заголовка и с CRLF сравнивается по телу
+	if d := DiffSchema("CREATE SCHEMA s;\r\n\r\nCREATE TABLE s.t ();\r\n", snapshotTitle+"\n\nCREATE TABLE s.t ();\n\nCREATE SCHEMA s;\n"); !d.Empty() {
+		t.Fatalf("order, headers and line endings must not matter: %+v", d)
+	}
+}
+
+func TestWriteSchemaFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	for _, snap := range []string{"first\n", "second\n"} {
+		if err := WriteSchemaFile(path, snap); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != snap {
+			t.Fatalf("got %q (%v), want %q", got, err, snap)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file must be renamed away: %v", err)
+	}
+}
@@ -5,17 +5,31 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ParseSQLDir сканирует каталог на наличие файлов *.sql формата: <version>_<name>.sql
 // и разделяет содержимое по маркерам: `-- +migrate Up` и `-- +migrate Down`.
 func ParseSQLDir(dir string) ([]Step, error) {
-	entries, err := os.ReadDir(dir)
+	// ошибка с путём каталога понятнее, чем "open ." от os.DirFS
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return ParseSQLFS(os.DirFS(dir))
+}
+
+// ParseSQLFS reads <version>_<name>.sql files from the root of fsys, so migrations
+// can come from embed.FS, zip archives or in-memory filesystems. Use fs.Sub to
+// point it at a subdirectory.
+func ParseSQLFS(fsys fs.FS) ([]Step, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -32,13 +46,24 @@ func ParseSQLDir(dir string) ([]Step, error) {
 		if !ok {
 			continue
 		}
-		full := filepath.Join(dir, name)
-		up, down, err := splitUpDown(full)
+		f, err := parseSQLFile(fsys, name)
 		if err != nil {
 			return nil, fmt.Errorf("%s: %w", name, err)
 		}
-		sum := checksum(up + "\n--DOWN--\n" + down)
-		steps = append(steps, Step{Version: ver, Name: title, UpSQL: up, DownSQL: down, Checksum: sum})
+		steps = append(steps, Step{
+			Version:          ver,
+			Name:             title,
+			Kind:             KindSQL,
+			UpSQL:            f.Up,
+			DownSQL:          f.Down,
+			upSource:         f.UpSource,
+			downSource:       f.DownSource,
+			NoTransaction:    f.NoTransaction,
+			StatementTimeout: f.StatementTimeout,
+			LockTimeout:      f.LockTimeout,
+			NoLint:           f.NoLint,
+			Checksum:         f.checksum(),
+		})
 	}
 	sort.Slice(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
 	return steps, nil
@@ -61,47 +86,227 @@ func splitVersionName(filename string) (int64, string, bool) {
 	return v, name, true
 }
 
-func splitUpDown(path string) (string, string, error) {
-	f, err := os.Open(path)
+// sqlFile — разобранное содержимое SQL-файла миграции.
+type sqlFile struct {
+	Up   string
+	Down string
+	// UpSource и DownSource — текст секций для деления на выражения с номерами
+	// строк файла; делит их Step.statements по правилам драйвера.
+	UpSource   sectionSource
+	DownSource sectionSource
+	// NoTransaction выставляется директивой `-- +migrate NoTransaction`.
+	NoTransaction bool
+	// StatementTimeout и LockTimeout задаются директивой
+	// `-- +migrate statement_timeout=10m lock_timeout=5s` и не входят в контрольную сумму.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// NoLint — правила линтера, отключенные директивой `-- +migrate nolint <rule>...`;
+	// директива тоже не входит в контрольную сумму.
+	NoLint []string
+}
+
+// checksum вычисляет контрольную сумму файла. Директивы добавляются к исходному
+// тексту только если они заданы, чтобы суммы старых файлов не менялись.
+func (f sqlFile) checksum() string {
+	src := f.Up + "\n--DOWN--\n" + f.Down
+	if f.NoTransaction {
+		src += "\n--NOTX--"
+	}
+	return checksum(src)
+}
+
+// parseDirective распознаёт строку вида `-- +migrate <directive>` и возвращает
+// директиву в нижнем регистре.
+func parseDirective(line string) (string, bool) {
+	l := strings.TrimSpace(line)
+	if !strings.HasPrefix(l, "--") {
+		return "", false
+	}
+	l = strings.TrimSpace(strings.TrimPrefix(l, "--"))
+	rest, ok := strings.CutPrefix(strings.ToLower(l), "+migrate")
+	// `+migrate` должен быть отдельным словом: `+migrations` и `+migrateUp` — комментарии
+	if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// parseSQLFile открывает файл миграции в fsys и разбирает его.
+func parseSQLFile(fsys fs.FS, name string) (res sqlFile, err error) {
+	f, err := fsys.Open(name)
 	if err != nil {
-		return "", "", err
+		return sqlFile{}, err
 	}
 	defer func() {
 		if cerr := f.Close(); cerr != nil && err == nil {
 			err = cerr
 		}
 	}()
-	var b strings.Builder
-	scanner := bufio.NewScanner(f)
+	return splitUpDown(f)
+}
+
+// splitUpDown разбирает содержимое файла миграции на секции Up/Down и директивы.
+func splitUpDown(r io.Reader) (res sqlFile, err error) {
+	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
 	mode := ""
 	var up, down strings.Builder
+	var upSrc, downSrc section
+	lineNo := 0
 	for scanner.Scan() {
 		line := scanner.Text()
-		ltrim := strings.TrimSpace(strings.ToLower(line))
-		switch ltrim {
-		case "-- +migrate up", "--+migrate up":
-			mode = "up"
-			continue
-		case "-- +migrate down", "--+migrate down":
-			mode = "down"
-			continue
+		lineNo++
+		cur := &upSrc
+		if mode == "down" {
+			cur = &downSrc
 		}
+		if directive, ok := parseDirective(line); ok {
+			switch directive {
+			case "up":
+				mode = "up"
+				continue
+			case "down":
+				mode = "down"
+				continue
+			case "notransaction":
+				res.NoTransaction = true
+				continue
+			case "statementbegin", "statementend":
+				if mode == "" {
+					return sqlFile{}, fmt.Errorf("line %d: %s outside of Up/Down section", lineNo, strings.TrimSpace(line))
+				}
+				if err := cur.block(directive == "statementbegin", lineNo); err != nil {
+					return sqlFile{}, err
+				}
+				// строка директивы остаётся в тексте секции, чтобы не менять контрольную сумму
+			default:
+				if rules, ok := strings.CutPrefix(directive, "nolint"); ok && (rules == "" || rules[0] == ' ') {
+					res.NoLint = append(res.NoLint, parseNoLint(rules)...)
+					continue
+				}
+				if strings.Contains(directive, "=") {
+					if err := res.parseOptions(directive); err != nil {
+						return sqlFile{}, fmt.Errorf("line %d: %w", lineNo, err)
+					}
+					continue
+				}
+			}
+		}
+		// строки до первого маркера игнорируются
 		if mode == "up" {
 			up.WriteString(line)
 			up.WriteByte('\n')
 		} else if mode == "down" {
 			down.WriteString(line)
 			down.WriteByte('\n')
-		} else {
-			// игнорировать строки до первого маркера
-			b.WriteString("")
+		}
+		if mode != "" {
+			cur.add(line, lineNo)
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return "", "", err
+		return sqlFile{}, err
+	}
+	for _, sec := range []*section{&upSrc, &downSrc} {
+		if sec.open > 0 {
+			return sqlFile{}, fmt.Errorf("line %d: StatementBegin without StatementEnd", sec.open)
+		}
+	}
+	res.UpSource, res.DownSource = upSrc.source(), downSrc.source()
+	res.Up = strings.TrimSpace(up.String())
+	res.Down = strings.TrimSpace(down.String())
+	return res, nil
+}
+
+// sectionSource — текст секции Up или Down, начиная со строки файла Offset+1.
+// Строки директив и другой секции заменены пустыми, поэтому номера строк
+// выражений, сдвинутые на Offset, совпадают с файлом.
+type sectionSource struct {
+	Text   string
+	Offset int
+}
+
+// section собирает sectionSource при разборе файла и проверяет парность
+// StatementBegin/StatementEnd: она не зависит от синтаксиса драйвера.
+type section struct {
+	buf   strings.Builder
+	first int // первая строка секции в файле, 0 — строк еще не было
+	last  int // последняя записанная строка
+	open  int // строка незакрытого StatementBegin
+}
+
+func (s *section) add(line string, lineNo int) {
+	if s.first == 0 {
+		s.first, s.last = lineNo, lineNo-1
+	}
+	for ; s.last < lineNo-1; s.last++ {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+	s.buf.WriteByte('\n')
+	s.last = lineNo
+}
+
+// block обрабатывает директиву StatementBegin (begin) или StatementEnd.
+func (s *section) block(begin bool, lineNo int) error {
+	switch {
+	case begin && s.open > 0:
+		return fmt.Errorf("line %d: nested StatementBegin (previous at line %d)", lineNo, s.open)
+	case begin:
+		s.open = lineNo
+	case s.open == 0:
+		return fmt.Errorf("line %d: StatementEnd without StatementBegin", lineNo)
+	default:
+		s.open = 0
+	}
+	return nil
+}
+
+func (s *section) source() sectionSource {
+	if s.first == 0 {
+		return sectionSource{}
+	}
+	return sectionSource{Text: s.buf.String(), Offset: s.first - 1}
+}
+
+// timeoutOptions — ключи директивы `-- +migrate key=value`, которые понимает разбор.
+var timeoutOptions = map[string]bool{"statement_timeout": true, "lock_timeout": true}
+
+// parseOptions разбирает директиву вида `statement_timeout=10m lock_timeout=5s`.
+// Неизвестные ключи пропускаются: о них сообщает Validate, а не каждая команда.
+func (f *sqlFile) parseOptions(directive string) error {
+	for _, opt := range strings.Fields(directive) {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return fmt.Errorf("malformed option %q, expected key=value", opt)
+		}
+		if !timeoutOptions[key] {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a duration like 5s or 10m", key, value)
+		}
+		if d == 0 {
+			d = TimeoutOff
+		}
+		if key == "statement_timeout" {
+			f.StatementTimeout = d
+		} else {
+			f.LockTimeout = d
+		}
+	}
+	return nil
+}
+
+// parseNoLint разбирает список правил директивы nolint через пробелы или запятые;
+// пустой список отключает все правила.
+func parseNoLint(rules string) []string {
+	names := strings.FieldsFunc(rules, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	if len(names) == 0 {
+		return []string{LintAll}
 	}
-	return strings.TrimSpace(up.String()), strings.TrimSpace(down.String()), nil
+	return names
 }
 
 func checksum(s string) string {
@@ -1,11 +1,18 @@
 package migrator
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
+// mapFile — файл миграции в fstest.MapFS.
+func mapFile(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
 func Test_splitVersionName(t *testing.T) {
 	v, name, ok := splitVersionName("1700000000000_init.sql")
 	if !ok || v != 1700000000000 || name != "init" {
@@ -27,11 +34,159 @@ DROP TABLE x;`
 	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	up, down, err := splitUpDown(file)
+	f, err := parseSQLFile(os.DirFS(dir), filepath.Base(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Up == "" || f.Down == "" {
+		t.Fatalf("expected both parts, got up=%q down=%q", f.Up, f.Down)
+	}
+	if f.NoTransaction {
+		t.Fatalf("expected transactional migration")
+	}
+}
+
+func Test_splitUpDown_NoTransaction(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "1_index.sql")
+	content := `-- +migrate NoTransaction
+-- +migrate Up
+CREATE INDEX CONCURRENTLY x_id_idx ON x(id);
+
+-- +migrate Down
+DROP INDEX CONCURRENTLY x_id_idx;`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseSQLFile(os.DirFS(dir), filepath.Base(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.NoTransaction {
+		t.Fatalf("expected NoTransaction directive to be recognised")
+	}
+	if f.Up != "CREATE INDEX CONCURRENTLY x_id_idx ON x(id);" {
+		t.Fatalf("unexpected up section: %q", f.Up)
+	}
+	plain := sqlFile{Up: f.Up, Down: f.Down}
+	if plain.checksum() == f.checksum() {
+		t.Fatalf("NoTransaction must change the checksum")
+	}
+	if plain.checksum() != checksum(f.Up+"\n--DOWN--\n"+f.Down) {
+		t.Fatalf("checksum of transactional files must stay compatible")
+	}
+}
+
+func Test_splitUpDown_Timeouts(t *testing.T) {
+	content := `-- +migrate lock_timeout=5s statement_timeout=10m
+-- +migrate Up
+ALTER TABLE x ADD COLUMN y int;
+-- +migrate Down
+ALTER TABLE x DROP COLUMN y;`
+	f, err := splitUpDown(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.LockTimeout != 5*time.Second || f.StatementTimeout != 10*time.Minute {
+		t.Fatalf("unexpected timeouts: lock %s, statement %s", f.LockTimeout, f.StatementTimeout)
+	}
+	if f.checksum() != (sqlFile{Up: f.Up, Down: f.Down}).checksum() {
+		t.Fatalf("timeouts must not change the checksum")
+	}
+	f, err = splitUpDown(strings.NewReader("-- +migrate Up\n-- +migrate statement_timeout=0\nSELECT 1;\n"))
+	if err != nil || f.StatementTimeout != TimeoutOff || f.Up != "SELECT 1;" {
+		t.Fatalf("expected disabled statement_timeout, got %+v (%v)", f, err)
+	}
+	for _, bad := range []string{"lock_timeout=soon", "lock_timeout=-1s", "lock_timeout=5s junk"} {
+		if _, err := splitUpDown(strings.NewReader("-- +migrate " + bad + "\n-- +migrate Up\nSELECT 1;\n")); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	// неизвестные ключи сообщает validate, разбор их пропускает
+	f, err = splitUpDown(strings.NewReader("-- +migrate work_mem=1s lock_timeout=5s\n-- +migrate Up\nSELECT 1;\n"))
+	if err != nil || f.LockTimeout != 5*time.Second {
+		t.Fatalf("unknown option must be skipped, got %+v (%v)", f, err)
+	}
+}
+
+func Test_parseDirective(t *testing.T) {
+	tests := []struct {
+		line, want string
+		ok         bool
+	}{
+		{"-- +migrate Up", "up", true},
+		{"  --   +migrate\tStatementBegin  ", "statementbegin", true},
+		{"-- +migrate", "", true},
+		{"-- +migrations are described in README", "", false},
+		{"-- +migrateUp", "", false},
+		{"SELECT 1; -- +migrate Up", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDirective(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseDirective(%q) = %q, %v; want %q, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_splitUpDown_NoLint(t *testing.T) {
+	plain := fstest.MapFS{"1_x.sql": mapFile("-- +migrate Up\nDROP TABLE x;\n")}
+	quiet := fstest.MapFS{"1_x.sql": mapFile("-- +migrate Up\n-- +migrate nolint\nDROP TABLE x;\n")}
+	a, err := ParseSQLFS(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseSQLFS(quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b[0].NoLint) != 1 || b[0].NoLint[0] != LintAll {
+		t.Fatalf("expected nolint all, got %q", b[0].NoLint)
+	}
+	if a[0].Checksum != b[0].Checksum {
+		t.Fatal("nolint directive must not change the checksum")
+	}
+}
+
+func TestParseSQLFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_seed.sql":    {Data: []byte("-- +migrate Up\nINSERT INTO x VALUES (1);\n-- +migrate Down\nDELETE FROM x;\n")},
+		"1_init.sql":    {Data: []byte("-- +migrate Up\nCREATE TABLE x(id int);\n-- +migrate Down\nDROP TABLE x;\n")},
+		"README.md":     {Data: []byte("not a migration")},
+		"sub/3_sub.sql": {Data: []byte("-- +migrate Up\nSELECT 1;\n")},
+	}
+	steps, err := ParseSQLFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Version != 1 || steps[1].Name != "seed" {
+		t.Fatalf("unexpected steps: %+v", steps)
+	}
+
+	dir := t.TempDir()
+	for name, f := range fsys {
+		if strings.Contains(name, "/") {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), f.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fromDir, err := ParseSQLDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range steps {
+		if fromDir[i].Checksum != steps[i].Checksum {
+			t.Fatalf("checksums must not depend on the source: %s vs %s", fromDir[i].Checksum, steps[i].Checksum)
+		}
+	}
+
+	sub, err := fs.Sub(fsys, "sub")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if up == "" || down == "" {
-		t.Fatalf("expected both parts, got up=%q down=%q", up, down)
+	if steps, err := ParseSQLFS(sub); err != nil || len(steps) != 1 || steps[0].Version != 3 {
+		t.Fatalf("unexpected sub steps: %+v (%v)", steps, err)
 	}
 }
@@ -0,0 +1,274 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is a single SQL statement of a migration section.
+type Statement struct {
+	SQL string
+	// Line is the line number where the statement starts (1-based).
+	Line int
+}
+
+// Syntax selects the lexical rules used to split a migration into statements.
+type Syntax int
+
+const (
+	// SyntaxPostgres follows PostgreSQL: quotes are doubled to escape them, E'...'
+	// strings use backslash escapes, $tag$ quoting and nested block comments.
+	SyntaxPostgres Syntax = iota
+	// SyntaxMySQL follows MySQL: backslash escapes in '...' and "...", `...`
+	// identifiers and # comments; there is no dollar quoting.
+	SyntaxMySQL
+	// SyntaxSQLite follows SQLite: quotes in '...', "..." and `...` are doubled to
+	// escape them; there are no backslash escapes, dollar quoting or nested comments.
+	SyntaxSQLite
+
+	// syntaxCount — число синтаксисов, по которым парсер заранее делит секции.
+	syntaxCount = int(SyntaxSQLite) + 1
+)
+
+// SyntaxReporter is implemented by drivers and connections whose SQL is not
+// split with the PostgreSQL rules.
+type SyntaxReporter interface {
+	Syntax() Syntax
+}
+
+// syntaxOf возвращает синтаксис драйвера или соединения; по умолчанию — PostgreSQL.
+func syntaxOf(v any) Syntax {
+	if sr, ok := v.(SyntaxReporter); ok {
+		return sr.Syntax()
+	}
+	return SyntaxPostgres
+}
+
+// tokenKind — вид лексемы, которую возвращает lexer.
+type tokenKind int
+
+const (
+	tokCode    tokenKind = iota // символ вне литералов и комментариев
+	tokLiteral                  // часть строки, идентификатора в кавычках или dollar-тела
+	tokComment                  // часть комментария
+)
+
+// lexer делит SQL на лексемы по правилам syntax. Состояние сохраняется между
+// вызовами next, поэтому литералы и блочные комментарии могут занимать несколько
+// строк; строчный комментарий заканчивается переводом строки или концом s.
+type lexer struct {
+	syntax    Syntax
+	quote     byte   // '\'', '"' или '`' внутри литерала
+	backslash bool   // E'...' строка с экранированием через обратный слэш
+	dollar    string // активный тег $tag$
+	depth     int    // вложенность блочных комментариев
+	exec      bool   // внутри исполняемого комментария MySQL /*! ... */
+}
+
+// next возвращает вид и длину лексемы, начинающейся с s[i].
+func (x *lexer) next(s string, i int) (tokenKind, int) {
+	mysql, pg := x.syntax == SyntaxMySQL, x.syntax == SyntaxPostgres
+	c := s[i]
+	switch {
+	case x.depth > 0:
+		if c == '*' && i+1 < len(s) && s[i+1] == '/' {
+			x.depth--
+			return tokComment, 2
+		}
+		if c == '/' && i+1 < len(s) && s[i+1] == '*' && pg {
+			// вложенные комментарии есть только в PostgreSQL
+			x.depth++
+			return tokComment, 2
+		}
+		return tokComment, 1
+	case x.quote != 0:
+		if x.backslash && c == '\\' && i+1 < len(s) {
+			return tokLiteral, 2
+		}
+		if c == x.quote {
+			if i+1 < len(s) && s[i+1] == x.quote {
+				return tokLiteral, 2
+			}
+			x.quote, x.backslash = 0, false
+		}
+		return tokLiteral, 1
+	case x.dollar != "":
+		if strings.HasPrefix(s[i:], x.dollar) {
+			n := len(x.dollar)
+			x.dollar = ""
+			return tokLiteral, n
+		}
+		return tokLiteral, 1
+	case c == '-' && i+1 < len(s) && s[i+1] == '-' && (!mysql || i+2 == len(s) || s[i+2] == ' ' || s[i+2] == '\t' || s[i+2] == '\n'),
+		c == '#' && mysql:
+		// в MySQL `--` начинает комментарий, только если за ним пробел
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+			return tokComment, end
+		}
+		return tokComment, len(s) - i
+	case mysql && (strings.HasPrefix(s[i:], "/*!") || strings.HasPrefix(s[i:], "/*M!")):
+		// исполняемый комментарий MySQL/MariaDB — это код, сервер выполняет его содержимое
+		x.exec = true
+		return tokCode, strings.IndexByte(s[i:], '!') + 1
+	case x.exec && c == '*' && i+1 < len(s) && s[i+1] == '/':
+		x.exec = false
+		return tokCode, 2
+	case c == '/' && i+1 < len(s) && s[i+1] == '*':
+		x.depth = 1
+		return tokComment, 2
+	case c == '\'' || c == '"' || c == '`' && !pg:
+		x.quote = c
+		switch {
+		case mysql:
+			// MySQL экранирует обратным слэшем в любых строках (кроме NO_BACKSLASH_ESCAPES)
+			x.backslash = c != '`'
+		case pg:
+			x.backslash = c == '\'' && i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') && (i == 1 || !isIdentChar(s[i-2]))
+		}
+		return tokLiteral, 1
+	case c == '$' && pg:
+		if tag := dollarTag(s[i:]); tag != "" && (i == 0 || !isIdentChar(s[i-1])) {
+			x.dollar = tag
+			return tokLiteral, len(tag)
+		}
+	}
+	return tokCode, 1
+}
+
+// splitter делит секцию миграции на отдельные выражения по `;` верхнего уровня.
+// Строковые литералы, идентификаторы в кавычках, dollar-quoted тела функций и
+// комментарии распознаёт lexer по правилам syntax; блок StatementBegin/StatementEnd
+// передаётся как есть.
+type splitter struct {
+	syntax Syntax
+	stmts  []Statement
+	buf    strings.Builder
+	start  int // строка первого значимого символа в buf, 0 — ещё нет
+
+	lex       lexer
+	block     bool // внутри StatementBegin/StatementEnd
+	blockLine int
+}
+
+// begin обрабатывает директиву StatementBegin.
+func (s *splitter) begin(lineNo int) error {
+	if s.block {
+		return fmt.Errorf("line %d: nested StatementBegin (previous at line %d)", lineNo, s.blockLine)
+	}
+	s.flush()
+	s.block = true
+	s.blockLine = lineNo
+	return nil
+}
+
+// end обрабатывает директиву StatementEnd.
+func (s *splitter) end(lineNo int) error {
+	if !s.block {
+		return fmt.Errorf("line %d: StatementEnd without StatementBegin", lineNo)
+	}
+	s.block = false
+	s.flush()
+	return nil
+}
+
+// close завершает секцию и возвращает найденные выражения.
+func (s *splitter) close() ([]Statement, error) {
+	if s.block {
+		return nil, fmt.Errorf("line %d: StatementBegin without StatementEnd", s.blockLine)
+	}
+	s.flush()
+	return s.stmts, nil
+}
+
+func (s *splitter) flush() {
+	sql := strings.TrimSpace(s.buf.String())
+	if s.start > 0 && sql != "" {
+		s.stmts = append(s.stmts, Statement{SQL: sql, Line: s.start})
+	}
+	s.buf.Reset()
+	s.start = 0
+	s.lex = lexer{syntax: s.syntax}
+}
+
+func (s *splitter) mark(lineNo int) {
+	if s.start == 0 {
+		s.start = lineNo
+	}
+}
+
+// feed добавляет очередную строку секции.
+func (s *splitter) feed(line string, lineNo int) {
+	if s.block {
+		if strings.TrimSpace(line) != "" {
+			s.mark(lineNo)
+		}
+		s.buf.WriteString(line)
+		s.buf.WriteByte('\n')
+		return
+	}
+	s.lex.syntax = s.syntax
+	for i := 0; i < len(line); {
+		kind, n := s.lex.next(line, i)
+		tok := line[i : i+n]
+		i += n
+		s.buf.WriteString(tok)
+		switch {
+		case kind == tokCode && tok == ";" && !s.lex.exec:
+			s.flush()
+		case kind == tokLiteral, kind == tokCode && tok != " " && tok != "\t" && tok != "\r":
+			s.mark(lineNo)
+		}
+	}
+	s.buf.WriteByte('\n')
+}
+
+// dollarTag возвращает открывающий тег вида $tag$ или $$ в начале строки.
+func dollarTag(s string) string {
+	if len(s) < 2 || s[0] != '$' {
+		return ""
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !isIdentChar(c) || (i == 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// SplitStatements splits a SQL section into statements with the PostgreSQL rules.
+// Line numbers are relative to the section; StatementBegin/StatementEnd directives
+// are honoured.
+func SplitStatements(sql string) ([]Statement, error) {
+	return SplitStatementsSyntax(sql, SyntaxPostgres)
+}
+
+// SplitStatementsSyntax is SplitStatements with the lexical rules of syntax.
+func SplitStatementsSyntax(sql string, syntax Syntax) ([]Statement, error) {
+	sp := splitter{syntax: syntax}
+	for i, line := range strings.Split(sql, "\n") {
+		if directive, ok := parseDirective(line); ok {
+			switch directive {
+			case "statementbegin":
+				if err := sp.begin(i + 1); err != nil {
+					return nil, err
+				}
+				continue
+			case "statementend":
+				if err := sp.end(i + 1); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		sp.feed(line, i+1)
+	}
+	return sp.close()
+}
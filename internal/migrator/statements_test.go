@@ -0,0 +1,163 @@
+package migrator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		want  []string
+		lines []int
+	}{
+		{
+			name:  "simple",
+			sql:   "CREATE TABLE a(id int);\nCREATE TABLE b(id int);",
+			want:  []string{"CREATE TABLE a(id int);", "CREATE TABLE b(id int);"},
+			lines: []int{1, 2},
+		},
+		{
+			name:  "no trailing semicolon",
+			sql:   "SELECT 1;\n\nSELECT 2",
+			want:  []string{"SELECT 1;", "SELECT 2"},
+			lines: []int{1, 3},
+		},
+		{
+			name:  "string literals",
+			sql:   "INSERT INTO t VALUES ('a;b', 'it''s;');\nINSERT INTO t VALUES (E'x\\';y');",
+			want:  []string{"INSERT INTO t VALUES ('a;b', 'it''s;');", "INSERT INTO t VALUES (E'x\\';y');"},
+			lines: []int{1, 2},
+		},
+		{
+			name:  "quoted identifier",
+			sql:   `CREATE TABLE "we;ird"(id int);`,
+			want:  []string{`CREATE TABLE "we;ird"(id int);`},
+			lines: []int{1},
+		},
+		{
+			name: "dollar quoted body",
+			sql: `CREATE FUNCTION f() RETURNS int AS $body$
+BEGIN
+  RETURN 1;
+END;
+$body$ LANGUAGE plpgsql;
+SELECT $$a;b$$;`,
+			want:  []string{"CREATE FUNCTION f() RETURNS int AS $body$\nBEGIN\n  RETURN 1;\nEND;\n$body$ LANGUAGE plpgsql;", "SELECT $$a;b$$;"},
+			lines: []int{1, 6},
+		},
+		{
+			name:  "comments",
+			sql:   "-- leading; comment\nSELECT 1; -- trailing;\n/* block; /* nested; */ */ SELECT 2;\n-- only comment",
+			want:  []string{"-- leading; comment\nSELECT 1;", "-- trailing;\n/* block; /* nested; */ */ SELECT 2;"},
+			lines: []int{2, 3},
+		},
+		{
+			name:  "positional parameter is not a dollar quote",
+			sql:   "PREPARE p AS SELECT $1;\nSELECT 2;",
+			want:  []string{"PREPARE p AS SELECT $1;", "SELECT 2;"},
+			lines: []int{1, 2},
+		},
+		{
+			name:  "statement block",
+			sql:   "SELECT 1;\n-- +migrate StatementBegin\nDO $$ BEGIN PERFORM 1; END $$;\nSELECT 2;\n-- +migrate StatementEnd\nSELECT 3;",
+			want:  []string{"SELECT 1;", "DO $$ BEGIN PERFORM 1; END $$;\nSELECT 2;", "SELECT 3;"},
+			lines: []int{1, 3, 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitStatements(tt.sql)
+			if err != nil {
+				t.Fatalf("split failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d statements, got %d: %+v", len(tt.want), len(got), got)
+			}
+			for i := range got {
+				if got[i].SQL != tt.want[i] {
+					t.Errorf("statement %d: got %q, want %q", i, got[i].SQL, tt.want[i])
+				}
+				if got[i].Line != tt.lines[i] {
+					t.Errorf("statement %d: got line %d, want %d", i, got[i].Line, tt.lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitStatements_UnbalancedBlock(t *testing.T) {
+	if _, err := SplitStatements("-- +migrate StatementBegin\nSELECT 1;"); err == nil {
+		t.Error("expected error for missing StatementEnd")
+	}
+	if _, err := SplitStatements("SELECT 1;\n-- +migrate StatementEnd"); err == nil {
+		t.Error("expected error for StatementEnd without StatementBegin")
+	}
+}
+
+func Test_splitUpDown_StatementLines(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "1_func.sql")
+	content := `-- +migrate Up
+CREATE TABLE x(id int);
+-- +migrate StatementBegin
+CREATE FUNCTION f() RETURNS trigger AS $$
+BEGIN
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION f();
+DROP TABLE x;`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseSQLFile(os.DirFS(dir), filepath.Base(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := Step{upSource: f.UpSource, downSource: f.DownSource}
+	up, err := step.statements(true, SyntaxPostgres)
+	if err != nil || len(up) != 2 || up[0].Line != 2 || up[1].Line != 4 {
+		t.Fatalf("unexpected up statements: %+v (%v)", up, err)
+	}
+	down, err := step.statements(false, SyntaxPostgres)
+	if err != nil || len(down) != 2 || down[1].Line != 13 {
+		t.Fatalf("unexpected down statements: %+v (%v)", down, err)
+	}
+	up, err = step.statements(true, SyntaxSQLite)
+	if err != nil || len(up) != 2 || up[1].Line != 4 {
+		t.Fatalf("unexpected sqlite statements: %+v (%v)", up, err)
+	}
+}
+
+func TestSplitStatementsSyntax_TriggerBody(t *testing.T) {
+	body := "CREATE TRIGGER t AFTER INSERT ON a FOR EACH ROW BEGIN\n  UPDATE b SET n = n + 1;\nEND;"
+	for _, syntax := range []Syntax{SyntaxMySQL, SyntaxSQLite} {
+		// без StatementBegin/StatementEnd тело режется по `;` внутри BEGIN ... END
+		stmts, err := SplitStatementsSyntax(body, syntax)
+		if err != nil || len(stmts) != 2 || stmts[1].SQL != "END;" {
+			t.Errorf("syntax %d: unexpected split %+v (%v)", syntax, stmts, err)
+		}
+		stmts, err = SplitStatementsSyntax("-- +migrate StatementBegin\n"+body+"\n-- +migrate StatementEnd", syntax)
+		if err != nil || len(stmts) != 1 || stmts[0].SQL != body {
+			t.Errorf("syntax %d: block must stay whole, got %+v (%v)", syntax, stmts, err)
+		}
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	inner := errors.New("syntax error")
+	err := error(&StatementError{Index: 2, Line: 7, Err: inner})
+	if err.Error() != "statement 2 (line 7): syntax error" {
+		t.Fatalf("unexpected message: %q", err.Error())
+	}
+	if !errors.Is(err, inner) {
+		t.Fatal("StatementError must unwrap to the cause")
+	}
+}
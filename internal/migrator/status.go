@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Status labels reported by Runner.Status.
+const (
+	StatePending          = "pending"
+	StateApplied          = "applied"
+	StateFailed           = "failed"
+	StateApplying         = "applying"
+	StateMissingFile      = "missing-file"
+	StateChecksumMismatch = "checksum-mismatch"
+	// StateChecksumUnknown marks a migration recorded with the legacy "go://checksum"
+	// placeholder; `gomigrator verify --accept` stores its real checksum.
+	StateChecksumUnknown = "checksum-unknown"
+)
+
+// StatusRow represents a single row in the migration status table.
+type StatusRow struct {
+	Version int64
+	Name    string
+	// Kind is empty for migrations that exist only in the database.
+	Kind Kind
+	// Checksum is the stored checksum, or the source checksum for pending migrations.
+	Checksum    string
+	Status      string
+	AppliedAt   *time.Time
+	UpdatedAt   time.Time
+	ExecutionMs int64
+	ErrorText   string
+	// OutOfOrder reports an applied migration that was applied after a newer one,
+	// or a pending migration older than the newest applied one.
+	OutOfOrder bool
+}
+
+// Status merges migration sources with the schema table and labels every migration.
+func (r *Runner) Status(ctx context.Context, steps []Step) ([]StatusRow, error) {
+	recs, err := r.loadRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mergeStatus(steps, recs), nil
+}
+
+// mergeStatus объединяет шаги из исходников с записями таблицы схемы.
+func mergeStatus(steps []Step, recs []Record) []StatusRow {
+	byVersion := make(map[int64]Step, len(steps))
+	for _, s := range steps {
+		byVersion[s.Version] = s
+	}
+	late := appliedOutOfOrder(recs)
+	applied := appliedChecksums(recs)
+	res := make([]StatusRow, 0, len(steps)+len(recs))
+	recorded := make(map[int64]struct{}, len(recs))
+	for _, rec := range recs {
+		recorded[rec.Version] = struct{}{}
+		_, ooo := late[rec.Version]
+		row := StatusRow{
+			Version:     rec.Version,
+			Name:        rec.Name,
+			Checksum:    rec.Checksum,
+			Status:      string(rec.Status),
+			AppliedAt:   rec.AppliedAt,
+			UpdatedAt:   rec.UpdatedAt,
+			ExecutionMs: rec.ExecutionMs,
+			OutOfOrder:  ooo,
+		}
+		if rec.ErrorText != nil {
+			row.ErrorText = *rec.ErrorText
+		}
+		s, ok := byVersion[rec.Version]
+		switch {
+		case !ok:
+			row.Status = StateMissingFile
+		case rec.Status == StatusApplied:
+			if drifts := detectDrift([]Step{s}, applied); len(drifts) > 0 {
+				row.Status = StateChecksumMismatch
+				if drifts[0].Unknown {
+					row.Status = StateChecksumUnknown
+				}
+			}
+		}
+		if ok {
+			row.Kind = s.Kind
+		}
+		res = append(res, row)
+	}
+	pending := make([]Step, 0, len(steps))
+	for _, s := range steps {
+		if _, ok := recorded[s.Version]; !ok {
+			pending = append(pending, s)
+		}
+	}
+	latePending := map[int64]struct{}{}
+	for _, s := range outOfOrder(pending, applied) {
+		latePending[s.Version] = struct{}{}
+	}
+	for _, s := range pending {
+		_, ooo := latePending[s.Version]
+		res = append(res, StatusRow{Version: s.Version, Name: s.Name, Kind: s.Kind, Checksum: s.Checksum, Status: StatePending, OutOfOrder: ooo})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res
+}
@@ -0,0 +1,54 @@
+package migrator
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_mergeStatus(t *testing.T) {
+	now := time.Now()
+	errText := "boom"
+	steps := []Step{
+		{Version: 1, Name: "init", Kind: KindSQL, Checksum: "a"},
+		{Version: 2, Name: "late", Kind: KindSQL, Checksum: "b"},
+		{Version: 3, Name: "edited", Kind: KindSQL, Checksum: "c-new"},
+		{Version: 4, Name: "broken", Kind: KindSQL, Checksum: "d"},
+		{Version: 6, Name: "next", Kind: KindGo, Checksum: "f"},
+	}
+	recs := []Record{
+		{Version: 1, Name: "init", Checksum: "a", Status: StatusApplied, AppliedAt: &now, ExecutionMs: 12},
+		{Version: 3, Name: "edited", Checksum: "c-old", Status: StatusApplied, AppliedAt: &now},
+		{Version: 4, Name: "broken", Checksum: "d", Status: StatusFailed, ErrorText: &errText},
+		{Version: 5, Name: "removed", Checksum: "e", Status: StatusApplied, AppliedAt: &now},
+	}
+	rows := mergeStatus(steps, recs)
+	want := []struct {
+		version int64
+		status  string
+		ooo     bool
+	}{
+		{1, StateApplied, false},
+		{2, StatePending, true},
+		{3, StateChecksumMismatch, false},
+		{4, StateFailed, false},
+		{5, StateMissingFile, false},
+		{6, StatePending, false},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d: %+v", len(want), len(rows), rows)
+	}
+	for i, w := range want {
+		if rows[i].Version != w.version || rows[i].Status != w.status || rows[i].OutOfOrder != w.ooo {
+			t.Errorf("row %d: got %d/%s/%v, want %d/%s/%v", i, rows[i].Version, rows[i].Status, rows[i].OutOfOrder, w.version, w.status, w.ooo)
+		}
+	}
+	if rows[0].ExecutionMs != 12 || rows[0].AppliedAt == nil {
+		t.Errorf("applied row must carry execution details: %+v", rows[0])
+	}
+	if rows[3].ErrorText != "boom" {
+		t.Errorf("failed row must carry error text, got %q", rows[3].ErrorText)
+	}
+	if rows[4].Kind != "" || rows[5].Kind != KindGo {
+		t.Errorf("unexpected kinds: %q %q", rows[4].Kind, rows[5].Kind)
+	}
+}
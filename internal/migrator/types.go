@@ -1,6 +1,12 @@
 package migrator
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
 
 // Direction represents the direction of a migration (Up or Down).
 type Direction int
@@ -12,16 +18,174 @@ const (
 	Down
 )
 
-// Step represents a single SQL migration step.
+func (d Direction) String() string {
+	if d == Down {
+		return "down"
+	}
+	return "up"
+}
+
+// Kind identifies the source of a migration step.
+type Kind string
+
+const (
+	// KindSQL marks a step parsed from a SQL file.
+	KindSQL Kind = "sql"
+	// KindGo marks a step registered as Go functions.
+	KindGo Kind = "go"
+)
+
+// GoTxFunc is a Go migration function run inside the migration transaction.
+// The context is the runner's context, so cancellation and deadlines reach it.
+type GoTxFunc func(ctx context.Context, tx pgx.Tx) error
+
+// GoPoolFunc is a Go migration function run outside a transaction on the connection pool.
+type GoPoolFunc func(ctx context.Context, pool *pgxpool.Pool) error
+
+// Step represents a single migration step of either kind.
+// SQL steps carry UpSQL/DownSQL, Go steps carry UpFn/DownFn or, when they run
+// without a transaction, UpPoolFn/DownPoolFn.
 type Step struct {
-	Version  int64
-	Name     string
-	UpSQL    string
-	DownSQL  string
-	Checksum string
+	Version int64
+	Name    string
+	Kind    Kind
+	UpSQL   string
+	DownSQL string
+	// upSource и downSource заполняет парсер файлов: секции делятся на выражения
+	// по правилам драйвера при выполнении с номерами строк файла.
+	upSource   sectionSource
+	downSource sectionSource
+
+	UpFn       GoTxFunc
+	DownFn     GoTxFunc
+	UpPoolFn   GoPoolFunc
+	DownPoolFn GoPoolFunc
+	Checksum   string
+	// NoTransaction runs the step outside a transaction: SQL with the NoTransaction
+	// directive or Go steps registered with pool functions.
+	NoTransaction bool
+	// StatementTimeout and LockTimeout override the runner defaults for this step;
+	// 0 keeps the default, TimeoutOff disables the timeout.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// NoLint lists lint rules suppressed for this step by the nolint directive;
+	// LintAll suppresses every rule.
+	NoLint []string
+}
+
+// TimeoutOff explicitly disables a timeout set by default (directive value 0).
+const TimeoutOff time.Duration = -1
+
+// MigrationStatus represents the status of a migration.
+type MigrationStatus string
+
+const (
+	// StatusApplied indicates the migration has been successfully applied.
+	StatusApplied MigrationStatus = "applied"
+	// StatusApplying indicates the migration is currently being applied.
+	StatusApplying MigrationStatus = "applying"
+	// StatusFailed indicates the migration attempt failed.
+	StatusFailed MigrationStatus = "failed"
+)
+
+// Record represents a migration record in the database.
+type Record struct {
+	Version     int64
+	Name        string
+	Checksum    string
+	Status      MigrationStatus
+	AppliedAt   *time.Time
+	UpdatedAt   time.Time
+	ExecutionMs int64
+	ErrorText   *string
+}
+
+// HistoryEntry is a single row of the append-only history table.
+type HistoryEntry struct {
+	ID      int64
+	Version int64
+	// Action is applied, rolled-back, failed, retry, repair, force, mark-applied,
+	// mark-rolled-back or accept-checksum.
+	Action string
+	// Direction is "up" or "down" for actions tied to a direction.
+	Direction string
+	Checksum  string
+	Duration  time.Duration
+	Error     string
+	Details   string
+	// Hostname, OSUser, ToolVersion and RunID identify the run that wrote the entry.
+	Hostname    string
+	OSUser      string
+	ToolVersion string
+	RunID       string
+	CreatedAt   time.Time
+}
+
+// HistoryFilter selects history entries; zero fields do not filter.
+type HistoryFilter struct {
+	Version int64
+	RunID   string
+	// Limit caps the number of newest entries returned.
+	Limit int
 }
 
 // Driver абстрагирует операции БД, используемые мигратором
 type Driver interface {
+	// EnsureTables создаёт таблицу схемы и журнал, если их ещё нет.
+	EnsureTables(ctx context.Context) error
+	// WithAdvisoryLock выполняет fn под межпроцессной блокировкой мигратора.
 	WithAdvisoryLock(ctx context.Context, fn func(context.Context) error) error
+	// LoadRecords возвращает все записи таблицы схемы в порядке версий.
+	LoadRecords(ctx context.Context) ([]Record, error)
+	// LoadHistory возвращает записи журнала от новых к старым.
+	LoadHistory(ctx context.Context, f HistoryFilter) ([]HistoryEntry, error)
+	// InTx выполняет fn в транзакции: фиксирует при успехе, откатывает при ошибке.
+	InTx(ctx context.Context, fn func(Conn) error) error
+	// WithConn выполняет fn на одном соединении без транзакции;
+	// каждое выражение фиксируется сразу.
+	WithConn(ctx context.Context, fn func(Conn) error) error
+	// Close освобождает соединения драйвера.
+	Close()
+}
+
+// Conn — транзакция или соединение драйвера: выполнение SQL миграций и учёт
+// в таблице схемы.
+type Conn interface {
+	// Exec выполняет одно выражение миграции.
+	Exec(ctx context.Context, sql string) error
+	// GetRecord возвращает запись версии; ok=false, если записи нет.
+	GetRecord(ctx context.Context, version int64) (rec Record, ok bool, err error)
+	// SaveRecord вставляет или обновляет запись версии; updated_at выставляет драйвер.
+	SaveRecord(ctx context.Context, rec Record) error
+	// DeleteRecord удаляет запись версии.
+	DeleteRecord(ctx context.Context, version int64) error
+	// AddHistory добавляет запись в журнал.
+	AddHistory(ctx context.Context, e HistoryEntry) error
+}
+
+// DDLCommitter is implemented by drivers that may commit DDL implicitly (MySQL).
+// A failed transactional migration can then be partially applied, so its
+// 'failed' row blocks Up until repaired.
+type DDLCommitter interface {
+	TransactionalDDL() bool
+}
+
+// TableChecker is implemented by drivers that can tell whether the schema table
+// exists, so planning can run on a database the migrator has never touched.
+type TableChecker interface {
+	SchemaTableExists(ctx context.Context) (bool, error)
+}
+
+// TimeoutSetter реализуется соединениями, поддерживающими таймауты миграции.
+// Значение 0 оставляет настройку без изменений, TimeoutOff отключает таймаут.
+type TimeoutSetter interface {
+	SetTimeouts(ctx context.Context, statement, lock time.Duration) error
+}
+
+// PgxConn реализуется соединениями драйвера PostgreSQL; Go-миграции получают
+// через него pgx.Tx (внутри транзакции) или пул (вне транзакции). PgxPool
+// должен оставаться доступным и после возврата соединения в пул.
+type PgxConn interface {
+	PgxTx() pgx.Tx
+	PgxPool() *pgxpool.Pool
 }
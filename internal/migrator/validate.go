@@ -0,0 +1,219 @@
+package migrator
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Problem is an issue in the migration sources found by Validate.
+type Problem struct {
+	// File is the migration file name; empty for problems of registered Go migrations.
+	File string
+	// Line is the 1-based line of the problem, 0 if it concerns the whole file.
+	Line    int
+	Message string
+}
+
+// String formats the problem as file:line: message.
+func (p Problem) String() string {
+	switch {
+	case p.File == "":
+		return p.Message
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	default:
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+}
+
+// ValidateOptions configures Validate.
+type ValidateOptions struct {
+	// RequireDown reports SQL migrations without statements in the Down section.
+	RequireDown bool
+	// GoFiles reports registered Go migrations without a <version>_<name>.go file
+	// in the source; leave it off when the source only holds SQL, e.g. an embed.FS.
+	GoFiles bool
+}
+
+// knownDirectives — директивы `-- +migrate`, которые понимает разбор файлов;
+// директивы с `=` сверяются с timeoutOptions, а nolint может перечислять правила.
+var knownDirectives = map[string]bool{
+	"up": true, "down": true, "notransaction": true, "statementbegin": true, "statementend": true, "nolint": true,
+}
+
+// Validate checks migration files in the root of fsys and the registered Go
+// migrations without a database: malformed file names, duplicate versions,
+// missing Up and Down sections, SQL before the first marker, unknown directives
+// and options, trigger and routine bodies cut at an inner ';', and Go migrations
+// without a source file. An error is returned only if the
+// source cannot be read. A nil fsys checks only the Go migrations.
+func Validate(fsys fs.FS, goSteps []GoStep, opts ValidateOptions) ([]Problem, error) {
+	var entries []fs.DirEntry
+	if fsys != nil {
+		var err error
+		if entries, err = fs.ReadDir(fsys, "."); err != nil {
+			return nil, err
+		}
+	}
+	var out []Problem
+	sqlFiles := map[int64]string{}
+	goFiles := map[int64]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(path.Ext(name))
+		if e.IsDir() || (ext != ".sql" && ext != ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		ver, _, ok := splitVersionName(name)
+		if ext == ".go" {
+			// прочие .go-файлы — служебный код пакета миграций
+			if ok {
+				goFiles[ver] = true
+			}
+			continue
+		}
+		if !ok || ver <= 0 {
+			out = append(out, Problem{File: name, Message: "file name does not match <version>_<name>.sql, the file is ignored"})
+			continue
+		}
+		if prev, dup := sqlFiles[ver]; dup {
+			out = append(out, Problem{File: name, Message: fmt.Sprintf("version %d is already used by %s", ver, prev)})
+			continue
+		}
+		sqlFiles[ver] = name
+		ps, err := validateSQLFile(fsys, name, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ps...)
+	}
+	for _, g := range goSteps {
+		if file, dup := sqlFiles[g.Version]; dup {
+			out = append(out, Problem{File: file, Message: fmt.Sprintf("version %d is also registered as go migration %s", g.Version, g.Name)})
+			continue
+		}
+		if opts.GoFiles && !goFiles[g.Version] {
+			out = append(out, Problem{Message: fmt.Sprintf("go migration %d_%s is registered but there is no %d_*.go file", g.Version, g.Name, g.Version)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].File != out[j].File {
+			return out[i].File < out[j].File
+		}
+		if out[i].Line != out[j].Line {
+			return out[i].Line < out[j].Line
+		}
+		return out[i].Message < out[j].Message
+	})
+	return out, nil
+}
+
+// validateSQLFile проверяет один SQL-файл: построчно — маркеры и директивы,
+// затем результат обычного разбора.
+func validateSQLFile(fsys fs.FS, name string, opts ValidateOptions) ([]Problem, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var out []Problem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	var sawUp, sawDown, before bool
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		directive, ok := parseDirective(line)
+		switch {
+		case ok && directive == "up":
+			sawUp = true
+		case ok && directive == "down":
+			sawDown = true
+		case ok && strings.Contains(directive, "="):
+			for _, opt := range strings.Fields(directive) {
+				if key, _, found := strings.Cut(opt, "="); found && !timeoutOptions[key] {
+					out = append(out, Problem{File: name, Line: lineNo, Message: fmt.Sprintf("unknown option %q", key)})
+				}
+			}
+		case ok && !knownDirectives[directive] && !strings.HasPrefix(directive, "nolint "):
+			out = append(out, Problem{File: name, Line: lineNo, Message: fmt.Sprintf("unknown directive %q", strings.TrimSpace(line))})
+		case !ok && !sawUp && !sawDown && !before:
+			// комментарии в шапке файла допустимы, а SQL до маркера молча пропускается
+			if l := strings.TrimSpace(line); l != "" && !strings.HasPrefix(l, "--") {
+				out = append(out, Problem{File: name, Line: lineNo, Message: "content before the first -- +migrate marker is ignored"})
+				before = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	parsed, err := parseSQLFile(fsys, name)
+	if err != nil {
+		// ошибки разбора (незакрытый StatementBegin, неверный таймаут) уже содержат номер строки
+		return append(out, Problem{File: name, Message: err.Error()}), nil
+	}
+	step := Step{upSource: parsed.UpSource, downSource: parsed.DownSource}
+	up, err := step.statements(true, SyntaxPostgres)
+	if err != nil {
+		return append(out, Problem{File: name, Message: err.Error()}), nil
+	}
+	down, err := step.statements(false, SyntaxPostgres)
+	if err != nil {
+		return append(out, Problem{File: name, Message: err.Error()}), nil
+	}
+	for _, st := range append(up, down...) {
+		if splitBody(st.SQL) {
+			out = append(out, Problem{File: name, Line: st.Line, Message: "BEGIN ... END body is cut at the first inner ';', wrap it in -- +migrate StatementBegin/StatementEnd"})
+		}
+	}
+	switch {
+	case !sawUp:
+		out = append(out, Problem{File: name, Message: "missing -- +migrate Up section"})
+	case len(up) == 0:
+		out = append(out, Problem{File: name, Message: "Up section has no statements"})
+	}
+	if opts.RequireDown {
+		switch {
+		case !sawDown:
+			out = append(out, Problem{File: name, Message: "missing -- +migrate Down section"})
+		case len(down) == 0:
+			out = append(out, Problem{File: name, Message: "Down section has no statements"})
+		}
+	}
+	return out, nil
+}
+
+// splitBody сообщает, что выражение — начало триггера или процедуры с телом
+// BEGIN ... END, разрезанного по `;` внутри тела. Такие тела в MySQL и SQLite
+// (и BEGIN ATOMIC в PostgreSQL) делятся на выражения без StatementBegin/StatementEnd.
+func splitBody(sql string) bool {
+	var b strings.Builder
+	lex := lexer{syntax: SyntaxPostgres}
+	for i := 0; i < len(sql); {
+		kind, n := lex.next(sql, i)
+		if kind == tokCode {
+			b.WriteString(sql[i : i+n])
+		} else {
+			b.WriteByte(' ')
+		}
+		i += n
+	}
+	words := strings.FieldsFunc(strings.ToUpper(b.String()), func(r rune) bool { return r > 0x7f || !isIdentChar(byte(r)) })
+	if len(words) == 0 || words[0] != "CREATE" || words[len(words)-1] == "END" {
+		return false
+	}
+	var routine, begin bool
+	for _, w := range words {
+		switch w {
+		case "TRIGGER", "PROCEDURE", "FUNCTION":
+			routine = true
+		case "BEGIN":
+			begin = true
+		}
+	}
+	return routine && begin
+}
@@ -0,0 +1,67 @@
+package migrator
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_ok.sql":        mapFile("-- header comment\n-- +migrate Up\n-- +migrate nolint drop-in-up\nCREATE TABLE a(id int);\n-- +migrate Down\nDROP TABLE a;\n"),
+		"2_nodown.sql":    mapFile("-- +migrate Up\nCREATE TABLE b(id int);\n"),
+		"2_twin.sql":      mapFile("-- +migrate Up\nSELECT 1;\n-- +migrate Down\nSELECT 1;\n"),
+		"3_noup.sql":      mapFile("CREATE TABLE c(id int);\n-- +migrate down\nDROP TABLE c;\n"),
+		"4_typo.sql":      mapFile("-- +migrate Up\n-- +migrate NoTransation\nSELECT 1;\n-- +migrate Down\n"),
+		"5_bad.sql":       mapFile("-- +migrate Up\n-- +migrate StatementBegin\nSELECT 1;\n"),
+		"10_trigger.sql":  mapFile("-- +migrate Up\nCREATE TRIGGER t AFTER INSERT ON a FOR EACH ROW BEGIN\n  UPDATE b SET n = n + 1;\nEND;\n-- +migrate Down\nDROP TRIGGER t;\n"),
+		"11_block.sql":    mapFile("-- +migrate Up\n-- +migrate StatementBegin\nCREATE TRIGGER t AFTER INSERT ON a FOR EACH ROW BEGIN\n  UPDATE b SET n = n + 1;\nEND;\n-- +migrate StatementEnd\nCREATE FUNCTION f() RETURNS trigger AS $$ BEGIN RETURN NEW; END $$ LANGUAGE plpgsql;\n-- +migrate Down\nDROP TRIGGER t;\n"),
+		"9_option.sql":    mapFile("-- +migrate Up\n-- +migrate lock_timeout=5s work_mem=64MB\nSELECT 1;\n-- +migrate Down\nSELECT 1;\n"),
+		"readme.sql":      mapFile(""),
+		"6_backfill.go":   mapFile("package migrations\n"),
+		"migrations.go":   mapFile("package migrations\n"),
+		"sub/7_skip.sql":  mapFile(""),
+		"notes_README.md": mapFile(""),
+	}
+	goSteps := []GoStep{{Version: 6, Name: "backfill"}, {Version: 8, Name: "orphan"}, {Version: 1, Name: "clash"}}
+
+	problems, err := Validate(fsys, goSteps, ValidateOptions{RequireDown: true, GoFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"go migration 8_orphan is registered but there is no 8_*.go file",
+		"10_trigger.sql:2: BEGIN ... END body is cut at the first inner ';', wrap it in -- +migrate StatementBegin/StatementEnd",
+		"1_ok.sql: version 1 is also registered as go migration clash",
+		"2_nodown.sql: missing -- +migrate Down section",
+		"2_twin.sql: version 2 is already used by 2_nodown.sql",
+		"3_noup.sql: missing -- +migrate Up section",
+		"3_noup.sql:1: content before the first -- +migrate marker is ignored",
+		"4_typo.sql: Down section has no statements",
+		"4_typo.sql:2: unknown directive \"-- +migrate NoTransation\"",
+		"5_bad.sql: line 2: StatementBegin without StatementEnd",
+		"9_option.sql:2: unknown option \"work_mem\"",
+		"readme.sql: file name does not match <version>_<name>.sql, the file is ignored",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d problems:\n%q", len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("problem %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	problems, err = Validate(fsys, nil, ValidateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		if p.File == "2_nodown.sql" || p.File == "4_typo.sql" && p.Line == 0 {
+			t.Errorf("unexpected problem without RequireDown: %s", p)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Drift describes an applied migration whose source changed after it was applied.
+type Drift struct {
+	Version int64
+	Name    string
+	Stored  string
+	Actual  string
+	// Unknown marks a legacy "go://checksum" placeholder: the migration was applied
+	// before Go migrations had real checksums, so it is unknown whether it changed.
+	Unknown bool
+}
+
+// DriftError is returned when applied migrations no longer match their sources.
+type DriftError struct {
+	Drifts []Drift
+}
+
+func (e *DriftError) Error() string {
+	ids := make([]string, 0, len(e.Drifts))
+	for _, d := range e.Drifts {
+		ids = append(ids, fmt.Sprintf("%d_%s", d.Version, d.Name))
+	}
+	return fmt.Sprintf("checksum mismatch for applied migrations: %s (run `gomigrator verify --accept` to accept the new checksums)", strings.Join(ids, ", "))
+}
+
+// legacyGoChecksum записывался для Go-миграций до появления настоящих контрольных сумм.
+const legacyGoChecksum = "go://checksum"
+
+// detectDrift сравнивает сохранённые контрольные суммы с вычисленными по исходникам.
+// Устаревшие заглушки Go-миграций возвращаются с Unknown: сверить их нельзя.
+func detectDrift(steps []Step, applied map[int64]string) []Drift {
+	var out []Drift
+	for _, s := range steps {
+		stored, ok := applied[s.Version]
+		if !ok || stored == s.Checksum {
+			continue
+		}
+		out = append(out, Drift{Version: s.Version, Name: s.Name, Stored: stored, Actual: s.Checksum, Unknown: stored == legacyGoChecksum})
+	}
+	return out
+}
+
+// knownDrift оставляет расхождения с известной сохранённой суммой: только они
+// блокируют up, заглушки лишь показываются в verify и status.
+func knownDrift(drifts []Drift) []Drift {
+	var out []Drift
+	for _, d := range drifts {
+		if !d.Unknown {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Verify compares checksums of applied migrations with their current sources.
+// Migrations recorded with the legacy "go://checksum" placeholder are reported
+// with Unknown set until AcceptChecksums stores their real checksums.
+func (r *Runner) Verify(ctx context.Context, steps []Step) ([]Drift, error) {
+	applied, err := r.loadApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return detectDrift(steps, applied), nil
+}
+
+// AcceptChecksums overwrites stored checksums of drifted migrations with the current ones
+// and records each replacement in the history table.
+// Legacy "go://checksum" placeholders are replaced as well.
+func (r *Runner) AcceptChecksums(ctx context.Context, steps []Step) ([]Drift, error) {
+	var drifts []Drift
+	err := r.DB.WithAdvisoryLock(ctx, func(ctx context.Context) error {
+		applied, err := r.loadApplied(ctx)
+		if err != nil {
+			return err
+		}
+		drifts = detectDrift(steps, applied)
+		return r.DB.InTx(ctx, func(c Conn) error {
+			for _, d := range drifts {
+				rec, ok, err := c.GetRecord(ctx, d.Version)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				rec.Checksum = d.Actual
+				if err := c.SaveRecord(ctx, rec); err != nil {
+					return err
+				}
+				details := fmt.Sprintf("checksum %s replaced with %s", d.Stored, d.Actual)
+				if err := r.audit(ctx, c, HistoryEntry{Version: d.Version, Action: "accept-checksum", Checksum: d.Actual, Details: details}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drifts, nil
+}
@@ -0,0 +1,43 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_detectDrift(t *testing.T) {
+	steps := []Step{
+		{Version: 1, Name: "init", Checksum: "aaa"},
+		{Version: 2, Name: "seed", Checksum: "bbb"},
+		{Version: 3, Name: "pending", Checksum: "ccc"},
+	}
+	applied := map[int64]string{1: "aaa", 2: "old"}
+	drifts := detectDrift(steps, applied)
+	if len(drifts) != 1 {
+		t.Fatalf("expected 1 drift, got %d", len(drifts))
+	}
+	if drifts[0].Version != 2 || drifts[0].Stored != "old" || drifts[0].Actual != "bbb" {
+		t.Fatalf("unexpected drift: %+v", drifts[0])
+	}
+	err := &DriftError{Drifts: drifts}
+	if !strings.Contains(err.Error(), "2_seed") {
+		t.Fatalf("expected version in error, got %q", err.Error())
+	}
+}
+
+func Test_detectDrift_LegacyGoChecksum(t *testing.T) {
+	steps := []Step{{Version: 1, Name: "go", Checksum: "sum"}}
+	applied := map[int64]string{1: legacyGoChecksum}
+	drifts := detectDrift(steps, applied)
+	if len(drifts) != 1 || !drifts[0].Unknown || drifts[0].Actual != "sum" {
+		t.Fatalf("legacy placeholder must be reported as unknown, got %+v", drifts)
+	}
+	// заглушка не блокирует up, но видна в verify и status
+	if known := knownDrift(drifts); len(known) != 0 {
+		t.Fatalf("legacy placeholder must not block up, got %+v", known)
+	}
+	rows := mergeStatus(steps, []Record{{Version: 1, Name: "go", Checksum: legacyGoChecksum, Status: StatusApplied}})
+	if len(rows) != 1 || rows[0].Status != StateChecksumUnknown {
+		t.Fatalf("expected %s status, got %+v", StateChecksumUnknown, rows)
+	}
+}
@@ -3,94 +3,514 @@ package migrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"strings"
 
 	icfg "migrator/internal/config"
+	imysql "migrator/internal/driver/mysql"
 	ipg "migrator/internal/driver/postgres"
+	isqlite "migrator/internal/driver/sqlite"
 	im "migrator/internal/migrator"
 )
 
+// Config is the migrator configuration. Set FS to load SQL migrations from an
+// fs.FS (for example embed.FS) instead of the Path directory.
+type Config = icfg.Config
+
+// DefaultConfig returns the configuration defaults used by the CLI.
+func DefaultConfig() Config { return icfg.Default() }
+
+// Plan lists migrations in the order they would be executed.
+type Plan = im.Plan
+
+// PlannedStep is a single migration of a Plan.
+type PlannedStep = im.PlannedStep
+
+// Direction is the direction of a planned migration.
+type Direction = im.Direction
+
+// Migration directions.
+const (
+	DirectionUp   = im.Up
+	DirectionDown = im.Down
+)
+
+// StatusRow is a single migration in the Status output.
+type StatusRow = im.StatusRow
+
+// Record is a row of the schema table.
+type Record = im.Record
+
+// HistoryEntry is a row of the append-only history table: every apply, rollback,
+// failure, retry and repair with the host, OS user, tool version and run ID.
+type HistoryEntry = im.HistoryEntry
+
+// HistoryFilter selects history entries by version and run ID; Limit caps the result.
+type HistoryFilter = im.HistoryFilter
+
+// SchemaDrift is the difference between a reference schema (the schema file or
+// the migrations) and the live database.
+type SchemaDrift = im.SchemaDrift
+
+// SchemaChange is a line-level difference of one object present on both sides.
+type SchemaChange = im.SchemaChange
+
+// Drift describes an applied migration whose checksum differs from its source.
+type Drift = im.Drift
+
+// Problem is an issue in the migration sources reported by Validate.
+type Problem = im.Problem
+
+// LintFinding is a dangerous operation or a missing reversal reported by Lint.
+type LintFinding = im.LintFinding
+
+// LintRule describes a built-in lint rule and its default severity.
+type LintRule = im.LintRule
+
+// Severity is the level of a lint rule: error, warning or off.
+type Severity = im.Severity
+
+// Lint rule severities.
+const (
+	SeverityError   = im.SeverityError
+	SeverityWarning = im.SeverityWarning
+	SeverityOff     = im.SeverityOff
+)
+
+// ErrInterrupted is wrapped by errors of runs stopped by context cancellation
+// or WithStop. Migrations completed before the stop stay applied.
+var ErrInterrupted = im.ErrInterrupted
+
+// WithStop returns a context that makes a run stop before the next migration once
+// stop is done, while the in-flight migration runs to completion. Cancelling the
+// returned context itself aborts the in-flight migration as well.
+func WithStop(ctx, stop context.Context) context.Context { return im.WithStop(ctx, stop) }
+
+// IsTransient reports whether err is a PostgreSQL lock_not_available,
+// deadlock_detected or serialization_failure error retried by the retry policy.
+func IsTransient(err error) bool { return im.IsTransient(err) }
+
+// LockHolder describes a database session holding the migrator lock.
+type LockHolder = im.LockHolder
+
+// LockTimeoutError is returned when the migrator lock is not acquired within
+// Config.LockWaitTimeout. It lists the sessions holding the lock.
+type LockTimeoutError = im.LockTimeoutError
+
+// NoTarget means "no version limit" for PlanUp and PlanDown.
+const NoTarget = im.NoTarget
+
+// connect открывает драйвер и создаёт служебные таблицы.
+func connect(ctx context.Context, c icfg.Config) (im.Driver, error) {
+	db, err := open(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.EnsureTables(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// open выбирает драйвер по схеме DSN: mysql:// — MySQL/MariaDB, sqlite:// — SQLite,
+// иначе PostgreSQL. Служебные таблицы не трогаются.
+func open(ctx context.Context, c icfg.Config) (im.Driver, error) {
+	lock := lockOptions(c)
+	switch {
+	case strings.HasPrefix(c.DSN, imysql.Scheme):
+		db, err := imysql.Open(c.DSN, c.SchemaTable, c.LockKey)
+		if err != nil {
+			return nil, err
+		}
+		db.Lock = lock
+		return db, nil
+	case strings.HasPrefix(c.DSN, isqlite.Scheme):
+		db, err := isqlite.Open(c.DSN, c.SchemaTable, c.LockKey)
+		if err != nil {
+			return nil, err
+		}
+		db.Lock = lock
+		return db, nil
+	}
+	db, err := ipg.Open(ctx, c.DSN, c.SchemaTable, c.LockKey)
+	if err != nil {
+		return nil, err
+	}
+	db.Lock = lock
+	return db, nil
+}
+
+func lockOptions(c icfg.Config) im.LockOptions {
+	return im.LockOptions{Timeout: c.LockWaitTimeout, OnWait: c.OnLockWait}
+}
+
+// newRunner создаёт Runner с политиками из конфигурации.
+func newRunner(db im.Driver, c icfg.Config) *im.Runner {
+	r := im.NewRunner(db)
+	r.AllowOutOfOrder = c.AllowOutOfOrder
+	r.StatementTimeout = c.StatementTimeout
+	r.LockTimeout = c.LockTimeout
+	r.SchemaFile = c.SchemaFile
+	r.Retry = im.RetryPolicy{Attempts: c.RetryAttempts, Backoff: c.RetryBackoff, MaxBackoff: c.RetryMaxBackoff, Jitter: c.RetryJitter}
+	return r
+}
+
+// loadSteps собирает SQL-миграции из c.FS (или каталога c.Path) и Go-миграции
+// из реестра в единую ленту. Для kind=go источник SQL-файлов необязателен.
+func loadSteps(c icfg.Config) ([]im.Step, error) {
+	var sqlSteps []im.Step
+	var err error
+	switch c.Kind {
+	case "sql":
+		if sqlSteps, err = parseSQL(c); err != nil {
+			return nil, err
+		}
+	case "go":
+		if sqlSteps, err = parseSQL(c); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown kind: %s", c.Kind)
+	}
+	return im.MergeSteps(sqlSteps, goReg.Steps())
+}
+
+// Validate checks the migration files and the registered Go migrations without
+// connecting to the database. Go migrations must have a <version>_<name>.go file
+// in Path; the check is skipped when SQL migrations come from Config.FS.
+func Validate(c icfg.Config) ([]Problem, error) {
+	fsys := c.FS
+	if fsys == nil {
+		// ошибка с путём каталога понятнее, чем "open ." от os.DirFS
+		if _, err := os.Stat(c.Path); err != nil {
+			return nil, err
+		}
+		fsys = os.DirFS(c.Path)
+	}
+	return im.Validate(fsys, goReg.Steps(), im.ValidateOptions{RequireDown: c.RequireDown, GoFiles: c.FS == nil})
+}
+
+// LintRules returns the built-in lint rules with their default severities.
+func LintRules() []LintRule { return append([]LintRule(nil), im.LintRules...) }
+
+// Lint checks the migrations for operations that lock or rewrite big tables
+// without connecting to the database. Config.Lint overrides rule severities.
+func Lint(c icfg.Config) ([]LintFinding, error) {
+	steps, err := loadSteps(c)
+	if err != nil {
+		return nil, err
+	}
+	return im.Lint(steps, lintSeverity(c))
+}
+
+// lintSeverity приводит уровни правил из конфигурации к Severity.
+func lintSeverity(c icfg.Config) map[string]Severity {
+	severity := make(map[string]Severity, len(c.Lint))
+	for rule, s := range c.Lint {
+		severity[rule] = Severity(strings.ToLower(strings.TrimSpace(s)))
+	}
+	return severity
+}
+
+func parseSQL(c icfg.Config) ([]im.Step, error) {
+	if c.FS != nil {
+		return im.ParseSQLFS(c.FS)
+	}
+	return im.ParseSQLDir(c.Path)
+}
+
 // RunUp applies all pending migrations according to the configuration.
 func RunUp(ctx context.Context, c icfg.Config) error {
-	db, err := ipg.Connect(ctx, c.DSN, c.SchemaTable, c.LockKey)
+	db, err := connect(ctx, c)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	r := im.NewRunner(db)
-	if c.Kind == "sql" {
-		steps, err := im.ParseSQLDir(c.Path)
-		if err != nil {
-			return err
-		}
-		return r.Up(ctx, steps)
-	} else if c.Kind == "go" {
-		return r.UpGo(ctx, goReg.Steps())
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown kind: %s", c.Kind)
+	return r.Up(ctx, steps)
+}
+
+// RunUpTo applies pending migrations with versions up to and including version.
+func RunUpTo(ctx context.Context, c icfg.Config, version int64) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.UpTo(ctx, steps, version)
 }
 
 // RunDown rolls back the last applied migration.
 func RunDown(ctx context.Context, c icfg.Config) error {
-	db, err := ipg.Connect(ctx, c.DSN, c.SchemaTable, c.LockKey)
+	db, err := connect(ctx, c)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	r := im.NewRunner(db)
-	if c.Kind == "sql" {
-		steps, err := im.ParseSQLDir(c.Path)
-		if err != nil {
-			return err
-		}
-		return r.Down(ctx, steps)
-	} else if c.Kind == "go" {
-		return r.DownGo(ctx, goReg.Steps())
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.Down(ctx, steps)
+}
+
+// RunDownTo rolls back all applied migrations with versions greater than version
+// in a single locked operation. Version 0 rolls back everything.
+func RunDownTo(ctx context.Context, c icfg.Config, version int64) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.DownTo(ctx, steps, version)
+}
+
+// RunDownSteps rolls back the n most recently applied migrations in a single locked operation.
+func RunDownSteps(ctx context.Context, c icfg.Config, n int) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown kind: %s", c.Kind)
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.DownSteps(ctx, steps, n)
 }
 
 // RunRedo rolls back and then reapplies the last migration.
 func RunRedo(ctx context.Context, c icfg.Config) error {
-	db, err := ipg.Connect(ctx, c.DSN, c.SchemaTable, c.LockKey)
+	db, err := connect(ctx, c)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	r := im.NewRunner(db)
-	if c.Kind == "sql" {
-		steps, err := im.ParseSQLDir(c.Path)
-		if err != nil {
-			return err
-		}
-		return r.Redo(ctx, steps)
-	} else if c.Kind == "go" {
-		if err := r.DownGo(ctx, goReg.Steps()); err != nil {
-			return err
-		}
-		return r.UpGo(ctx, goReg.Steps())
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown kind: %s", c.Kind)
+	return r.Redo(ctx, steps)
 }
 
-// Status returns the migration status for all migrations.
-func Status(ctx context.Context, c icfg.Config) ([]im.StatusRow, error) {
-	db, err := ipg.Connect(ctx, c.DSN, c.SchemaTable, c.LockKey)
+// Status returns the migration status for all migrations known to the sources
+// or recorded in the database.
+func Status(ctx context.Context, c icfg.Config) ([]StatusRow, error) {
+	db, err := connect(ctx, c)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
-	r := im.NewRunner(db)
-	return r.Status(ctx)
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.Status(ctx, steps)
 }
 
 // DBVersion returns the current database migration version.
 func DBVersion(ctx context.Context, c icfg.Config) (int64, error) {
-	db, err := ipg.Connect(ctx, c.DSN, c.SchemaTable, c.LockKey)
+	db, err := connect(ctx, c)
 	if err != nil {
 		return 0, err
 	}
 	defer db.Close()
-	r := im.NewRunner(db)
+	r := newRunner(db, c)
 	return r.DBVersion(ctx)
 }
+
+// History returns entries of the history table matching f, newest first.
+func History(ctx context.Context, c icfg.Config, f HistoryFilter) ([]HistoryEntry, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return newRunner(db, c).History(ctx, f)
+}
+
+// SchemaSnapshot returns a normalised schema snapshot of the database built from
+// pg_catalog, in the format written to Config.SchemaFile.
+func SchemaSnapshot(ctx context.Context, c icfg.Config) (string, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	return newRunner(db, c).SchemaSnapshot(ctx)
+}
+
+// WriteSchemaFile atomically replaces the file at path with a snapshot returned by SchemaSnapshot.
+func WriteSchemaFile(path, snap string) error { return im.WriteSchemaFile(path, snap) }
+
+// CheckSchema compares Config.SchemaFile with a fresh snapshot of the database.
+func CheckSchema(ctx context.Context, c icfg.Config) (SchemaDrift, error) {
+	if c.SchemaFile == "" {
+		return SchemaDrift{}, errors.New("schema_file is not set")
+	}
+	db, err := connect(ctx, c)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	defer db.Close()
+	return newRunner(db, c).CheckSchema(ctx, c.SchemaFile)
+}
+
+// Diff applies the migrations up to the database's current version to a throwaway
+// database on the same server and compares its schema with the live database,
+// revealing changes made by hand. The role needs the CREATEDB privilege.
+func Diff(ctx context.Context, c icfg.Config) (SchemaDrift, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	defer db.Close()
+	steps, err := loadSteps(c)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	return newRunner(db, c).Diff(ctx, steps)
+}
+
+// Verify compares checksums of applied migrations with the migration sources.
+func Verify(ctx context.Context, c icfg.Config) ([]Drift, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.Verify(ctx, steps)
+}
+
+// AcceptChecksums stores the current checksums for drifted migrations and returns them.
+func AcceptChecksums(ctx context.Context, c icfg.Config) ([]Drift, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.AcceptChecksums(ctx, steps)
+}
+
+// PlanUp returns the migrations RunUpTo would apply, without executing them.
+// Pass NoTarget to plan all pending migrations.
+func PlanUp(ctx context.Context, c icfg.Config, version int64) (Plan, error) {
+	// dry-run не создает и не меняет служебные таблицы
+	db, err := open(ctx, c)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	r.AllowMissingTables = true
+	steps, err := loadSteps(c)
+	if err != nil {
+		return Plan{}, err
+	}
+	return r.PlanUp(ctx, steps, version)
+}
+
+// PlanDown returns the migrations a rollback would revert, without executing them.
+// Migrations newer than version are included, at most n of them;
+// use version -1 to limit by n only and n <= 0 to limit by version only.
+func PlanDown(ctx context.Context, c icfg.Config, version int64, n int) (Plan, error) {
+	// dry-run не создает и не меняет служебные таблицы
+	db, err := open(ctx, c)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	r.AllowMissingTables = true
+	steps, err := loadSteps(c)
+	if err != nil {
+		return Plan{}, err
+	}
+	return r.PlanDown(ctx, steps, version, n)
+}
+
+// Repair removes schema table rows stuck in 'failed' or 'applying' state, so
+// the next RunUp retries those migrations. It returns the removed rows.
+func Repair(ctx context.Context, c icfg.Config) ([]Record, error) {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	return r.Repair(ctx)
+}
+
+// Force records every migration up to and including version as applied and
+// removes records of newer ones, without executing any migration code.
+func Force(ctx context.Context, c icfg.Config, version int64) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.Force(ctx, steps, version)
+}
+
+// MarkApplied records the migration as applied without executing it.
+func MarkApplied(ctx context.Context, c icfg.Config, version int64) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	steps, err := loadSteps(c)
+	if err != nil {
+		return err
+	}
+	return r.MarkApplied(ctx, steps, version)
+}
+
+// MarkRolledBack removes the record of the migration without executing its Down section.
+func MarkRolledBack(ctx context.Context, c icfg.Config, version int64) error {
+	db, err := connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r := newRunner(db, c)
+	return r.MarkRolledBack(ctx, version)
+}
@@ -2,9 +2,13 @@ package migrator
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	icfg "migrator/internal/config"
+	im "migrator/internal/migrator"
 )
 
 func TestPublicAPI_InvalidDSN(t *testing.T) {
@@ -27,6 +31,27 @@ func TestPublicAPI_InvalidDSN(t *testing.T) {
 		}
 	})
 
+	t.Run("RunUpTo", func(t *testing.T) {
+		err := RunUpTo(ctx, cfg, 1)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("RunDownTo", func(t *testing.T) {
+		err := RunDownTo(ctx, cfg, 0)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("RunDownSteps", func(t *testing.T) {
+		err := RunDownSteps(ctx, cfg, 2)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
 	t.Run("RunRedo", func(t *testing.T) {
 		err := RunRedo(ctx, cfg)
 		if err == nil {
@@ -47,11 +72,185 @@ func TestPublicAPI_InvalidDSN(t *testing.T) {
 			t.Error("expected error with invalid DSN, got nil")
 		}
 	})
+
+	t.Run("PlanUp", func(t *testing.T) {
+		_, err := PlanUp(ctx, cfg, NoTarget)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("PlanDown", func(t *testing.T) {
+		_, err := PlanDown(ctx, cfg, -1, 1)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		_, err := Repair(ctx, cfg)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		err := Force(ctx, cfg, 1)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("MarkApplied", func(t *testing.T) {
+		err := MarkApplied(ctx, cfg, 1)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("MarkRolledBack", func(t *testing.T) {
+		err := MarkRolledBack(ctx, cfg, 1)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		_, err := Verify(ctx, cfg)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
+
+	t.Run("AcceptChecksums", func(t *testing.T) {
+		_, err := AcceptChecksums(ctx, cfg)
+		if err == nil {
+			t.Error("expected error with invalid DSN, got nil")
+		}
+	})
 }
 
-func TestPublicAPI_UnknownKind(_ *testing.T) {
-	// Мы не можем легко протестировать успех без реальной БД или сложных моков,
-	// но можем протестировать проверку типа миграции (kind), если DSN корректен по формату,
-	// но подключение не требуется немедленно (хотя Connect в api.go вызывается сразу).
-	// Однако, Connect вызывает pgxpool.Connect, который может попытаться соединиться.
+func TestLoadSteps(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	if _, err := loadSteps(icfg.Config{Kind: "sql", Path: missing}); err == nil {
+		t.Error("expected error for missing sql directory")
+	}
+	if _, err := loadSteps(icfg.Config{Kind: "go", Path: missing}); err != nil {
+		t.Errorf("go kind must not require sql directory: %v", err)
+	}
+	if _, err := loadSteps(icfg.Config{Kind: "rust", Path: missing}); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+}
+
+func TestPublicAPI_UnknownKind(t *testing.T) {
+	cfg := icfg.Config{DSN: "sqlite://" + filepath.Join(t.TempDir(), "db.sqlite"), Kind: "rust", SchemaTable: "schema_migrations"}
+	if err := RunUp(context.Background(), cfg); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+}
+
+func TestPublicAPI_SQLite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "1_init.sql"), "-- +migrate Up\nCREATE TABLE foo(id INTEGER);\n-- +migrate Down\nDROP TABLE foo;\n")
+	mustWrite(t, filepath.Join(dir, "2_seed.sql"), "-- +migrate Up\nINSERT INTO foo VALUES (1);\n-- +migrate Down\nDELETE FROM foo;\n")
+	cfg := icfg.Config{DSN: "sqlite://" + filepath.Join(dir, "db.sqlite"), Path: dir, Kind: "sql", SchemaTable: "schema_migrations"}
+
+	// dry-run на новой БД видит все миграции ожидающими и не создает служебные таблицы
+	plan, err := PlanUp(ctx, cfg, NoTarget)
+	if err != nil || len(plan.Steps) != 2 {
+		t.Fatalf("unexpected up plan %+v (%v)", plan, err)
+	}
+	db, err := open(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := db.(im.TableChecker).SchemaTableExists(ctx)
+	db.Close()
+	if err != nil || exists {
+		t.Fatalf("dry-run must not create the schema table (exists=%v, %v)", exists, err)
+	}
+
+	if err := RunUp(ctx, cfg); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if v, err := DBVersion(ctx, cfg); err != nil || v != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", v, err)
+	}
+	rows, err := Status(ctx, cfg)
+	if err != nil || len(rows) != 2 || rows[1].Status != "applied" {
+		t.Fatalf("unexpected status %+v (%v)", rows, err)
+	}
+	plan, err = PlanDown(ctx, cfg, -1, 1)
+	if err != nil || len(plan.Steps) != 1 || plan.Steps[0].Version != 2 {
+		t.Fatalf("unexpected down plan %+v (%v)", plan, err)
+	}
+	if err := RunDown(ctx, cfg); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if v, err := DBVersion(ctx, cfg); err != nil || v != 1 {
+		t.Fatalf("expected version 1, got %d (%v)", v, err)
+	}
+}
+
+func TestPublicAPI_SQLiteMemory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "1_init.sql"), "-- +migrate Up\nCREATE TABLE foo(id INTEGER);\n-- +migrate Down\nDROP TABLE foo;\n")
+	mustWrite(t, filepath.Join(dir, "2_seed.sql"), "-- +migrate Up\nINSERT INTO foo VALUES (1);\n-- +migrate Down\nDELETE FROM foo;\n")
+	for _, dsn := range []string{"sqlite://:memory:", "sqlite://file:api?mode=memory"} {
+		cfg := icfg.Config{DSN: dsn, Path: dir, Kind: "sql", SchemaTable: "schema_migrations"}
+		if err := RunUp(ctx, cfg); err != nil {
+			t.Fatalf("%s: up: %v", dsn, err)
+		}
+	}
+	// база живет, пока открыт пул Migrator
+	m, err := New(WithDSN("sqlite://:memory:"), WithDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if v, err := m.Version(ctx); err != nil || v != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", v, err)
+	}
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect_MySQLInvalidDSN(t *testing.T) {
+	_, err := connect(context.Background(), icfg.Config{DSN: "mysql:///db"})
+	if err == nil {
+		t.Error("expected error for mysql DSN without host, got nil")
+	}
+}
+
+func TestPublicAPI_FS(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"1_init.sql": {Data: []byte("-- +migrate Up\nCREATE TABLE foo(id INTEGER);\n-- +migrate Down\nDROP TABLE foo;\n")},
+	}
+	// Path указывает в несуществующий каталог: миграции должны читаться из FS
+	cfg := icfg.Config{
+		DSN:         "sqlite://" + filepath.Join(t.TempDir(), "db.sqlite"),
+		Path:        filepath.Join(t.TempDir(), "missing"),
+		FS:          fsys,
+		Kind:        "sql",
+		SchemaTable: "schema_migrations",
+	}
+	if err := RunUp(ctx, cfg); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if v, err := DBVersion(ctx, cfg); err != nil || v != 1 {
+		t.Fatalf("expected version 1, got %d (%v)", v, err)
+	}
 }
@@ -0,0 +1,534 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	icfg "migrator/internal/config"
+	imysql "migrator/internal/driver/mysql"
+	ipg "migrator/internal/driver/postgres"
+	"migrator/internal/driver/sqldb"
+	isqlite "migrator/internal/driver/sqlite"
+	im "migrator/internal/migrator"
+)
+
+// SQLDialect names the database behind a *sql.DB passed to WithSQLDB.
+type SQLDialect string
+
+// Dialects supported by WithSQLDB.
+const (
+	DialectMySQL  SQLDialect = "mysql"
+	DialectSQLite SQLDialect = "sqlite"
+)
+
+// Migrator is a long-lived handle for running migrations from application code.
+// It is safe for concurrent use; runs are serialized by the database lock.
+type Migrator struct {
+	cfg    icfg.Config
+	dir    string
+	source func() ([]im.Step, error)
+
+	mu      sync.Mutex
+	db      im.Driver
+	owned   bool
+	ensured bool
+	runner  *im.Runner
+}
+
+// options собирает параметры New до создания драйвера.
+type options struct {
+	cfg     icfg.Config
+	pool    *pgxpool.Pool
+	sqlDB   *sql.DB
+	dialect SQLDialect
+	dbs     int
+	dir     string
+}
+
+// Option configures a Migrator.
+type Option func(*options) error
+
+// WithPool runs migrations on an existing PostgreSQL pool. The pool is not closed by Close.
+func WithPool(pool *pgxpool.Pool) Option {
+	return func(o *options) error {
+		if pool == nil {
+			return errors.New("nil pool")
+		}
+		o.pool = pool
+		o.dbs++
+		return nil
+	}
+}
+
+// WithSQLDB runs migrations on an existing database/sql handle of the given dialect.
+// The handle is not closed by Close.
+func WithSQLDB(db *sql.DB, dialect SQLDialect) Option {
+	return func(o *options) error {
+		if db == nil {
+			return errors.New("nil *sql.DB")
+		}
+		if dialect != DialectMySQL && dialect != DialectSQLite {
+			return fmt.Errorf("unsupported dialect: %s", dialect)
+		}
+		o.sqlDB, o.dialect = db, dialect
+		o.dbs++
+		return nil
+	}
+}
+
+// WithDSN opens a connection pool from a postgres://, mysql:// or sqlite:// DSN.
+// The pool is owned by the Migrator and closed by Close.
+func WithDSN(dsn string) Option {
+	return func(o *options) error {
+		o.cfg.DSN = dsn
+		o.dbs++
+		return nil
+	}
+}
+
+// WithFS loads SQL migrations from the root of fsys, e.g. an embed.FS passed through fs.Sub.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) error {
+		o.cfg.FS = fsys
+		return nil
+	}
+}
+
+// WithDir loads SQL migrations from a directory.
+func WithDir(path string) Option {
+	return func(o *options) error {
+		o.dir = path
+		return nil
+	}
+}
+
+// WithSchemaTable sets the schema table name (default schema_migrations).
+func WithSchemaTable(name string) Option {
+	return func(o *options) error {
+		if name == "" {
+			return errors.New("empty schema table name")
+		}
+		o.cfg.SchemaTable = name
+		return nil
+	}
+}
+
+// WithLockKey sets the key of the migrator lock.
+func WithLockKey(key int64) Option {
+	return func(o *options) error {
+		o.cfg.LockKey = key
+		return nil
+	}
+}
+
+// WithLockWaitTimeout limits how long a run waits for the migrator lock held by
+// another process; zero waits until the context is done.
+func WithLockWaitTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		o.cfg.LockWaitTimeout = d
+		return nil
+	}
+}
+
+// WithLockWaitHandler sets a callback invoked once when the lock is busy, with the
+// sessions holding it (PID, application, client address, query).
+func WithLockWaitHandler(fn func([]LockHolder)) Option {
+	return func(o *options) error {
+		o.cfg.OnLockWait = fn
+		return nil
+	}
+}
+
+// WithTimeouts sets the default statement_timeout and lock_timeout applied to every
+// migration (PostgreSQL only); zero keeps the database setting. Files override them
+// with the `-- +migrate statement_timeout=... lock_timeout=...` directive.
+func WithTimeouts(statement, lock time.Duration) Option {
+	return func(o *options) error {
+		o.cfg.StatementTimeout = statement
+		o.cfg.LockTimeout = lock
+		return nil
+	}
+}
+
+// WithRetry retries a transactional migration up to attempts times in total when it
+// fails with lock_not_available, deadlock_detected or serialization_failure. The delay
+// starts at backoff and doubles up to 30s, randomized by ±20%; every failed attempt
+// is recorded in the history table.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) error {
+		if attempts < 0 || backoff < 0 {
+			return errors.New("retry attempts and backoff must not be negative")
+		}
+		o.cfg.RetryAttempts = attempts
+		o.cfg.RetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithSchemaFile makes Up, Down and Redo write a schema snapshot to path after a
+// successful run (PostgreSQL only).
+func WithSchemaFile(path string) Option {
+	return func(o *options) error {
+		o.cfg.SchemaFile = path
+		return nil
+	}
+}
+
+// WithRequireDown makes Validate report SQL migrations without a Down section
+// (enabled by default).
+func WithRequireDown(require bool) Option {
+	return func(o *options) error {
+		o.cfg.RequireDown = require
+		return nil
+	}
+}
+
+// WithLintSeverity overrides the severities of lint rules used by Lint.
+func WithLintSeverity(severity map[string]Severity) Option {
+	return func(o *options) error {
+		if o.cfg.Lint == nil {
+			o.cfg.Lint = make(map[string]string, len(severity))
+		}
+		for rule, s := range severity {
+			o.cfg.Lint[rule] = string(s)
+		}
+		return nil
+	}
+}
+
+// WithAllowOutOfOrder permits applying pending migrations older than the newest applied one.
+func WithAllowOutOfOrder(allow bool) Option {
+	return func(o *options) error {
+		o.cfg.AllowOutOfOrder = allow
+		return nil
+	}
+}
+
+// New creates a Migrator. Exactly one of WithPool, WithSQLDB or WithDSN is required.
+// SQL migrations come from WithFS or WithDir; Go migrations registered with
+// Register are always included. New does not touch the database: the DSN pool
+// and service tables are created on first use.
+func New(opts ...Option) (*Migrator, error) {
+	o := options{cfg: icfg.Default()}
+	o.cfg.Path = ""
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.dbs != 1 {
+		return nil, errors.New("exactly one of WithPool, WithSQLDB or WithDSN is required")
+	}
+	if o.cfg.FS != nil && o.dir != "" {
+		return nil, errors.New("WithFS and WithDir are mutually exclusive")
+	}
+	m := &Migrator{cfg: o.cfg, dir: o.dir, source: o.source()}
+	switch {
+	case o.pool != nil:
+		m.db = &ipg.DB{Pool: o.pool, SchemaTable: o.cfg.SchemaTable, LockKey: o.cfg.LockKey, Lock: lockOptions(o.cfg)}
+	case o.sqlDB != nil:
+		db := &sqldb.DB{SQL: o.sqlDB, SchemaTable: o.cfg.SchemaTable, LockKey: o.cfg.LockKey, Dialect: imysql.Dialect{}, Lock: lockOptions(o.cfg)}
+		if o.dialect == DialectSQLite {
+			// путь к файлу драйвер прочитает из PRAGMA database_list при блокировке
+			db.Dialect = isqlite.Dialect{}
+		}
+		m.db = db
+	}
+	return m, nil
+}
+
+// source возвращает загрузчик шагов: SQL из FS или каталога плюс Go-реестр.
+func (o options) source() func() ([]im.Step, error) {
+	fsys, dir := o.cfg.FS, o.dir
+	return func() ([]im.Step, error) {
+		var sqlSteps []im.Step
+		var err error
+		switch {
+		case fsys != nil:
+			sqlSteps, err = im.ParseSQLFS(fsys)
+		case dir != "":
+			sqlSteps, err = im.ParseSQLDir(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return im.MergeSteps(sqlSteps, goReg.Steps())
+	}
+}
+
+// Close releases the connection pool if it was opened by WithDSN.
+func (m *Migrator) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owned && m.db != nil {
+		m.db.Close()
+		m.db, m.ensured, m.runner = nil, false, nil
+	}
+}
+
+// ensure подключается по DSN и создаёт служебные таблицы при первом обращении.
+func (m *Migrator) ensure(ctx context.Context) (*im.Runner, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ensured {
+		return m.runner, nil
+	}
+	if m.db == nil {
+		// connect создаёт служебные таблицы сам
+		db, err := connect(ctx, m.cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.db, m.owned = db, true
+	} else if err := m.db.EnsureTables(ctx); err != nil {
+		return nil, err
+	}
+	m.runner = newRunner(m.db, m.cfg)
+	m.ensured = true
+	return m.runner, nil
+}
+
+// prepare готовит таблицы и загружает миграции.
+func (m *Migrator) prepare(ctx context.Context) (*im.Runner, []im.Step, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	steps, err := m.source()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, steps, nil
+}
+
+// planner готовит Runner для dry-run: служебные таблицы не создаются, а их
+// отсутствие означает, что ничего не применено.
+func (m *Migrator) planner(ctx context.Context) (*im.Runner, []im.Step, error) {
+	m.mu.Lock()
+	if m.db == nil {
+		db, err := open(ctx, m.cfg)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, nil, err
+		}
+		// ensure при следующем обращении создаст таблицы на этом же драйвере
+		m.db, m.owned = db, true
+	}
+	r := newRunner(m.db, m.cfg)
+	m.mu.Unlock()
+	r.AllowMissingTables = true
+	steps, err := m.source()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, steps, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.UpTo(ctx, NoTarget)
+}
+
+// UpTo applies pending migrations with versions up to and including version.
+func (m *Migrator) UpTo(ctx context.Context, version int64) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.UpTo(ctx, steps, version)
+}
+
+// Down rolls back the last applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.DownSteps(ctx, 1)
+}
+
+// DownTo rolls back all applied migrations with versions greater than version.
+func (m *Migrator) DownTo(ctx context.Context, version int64) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.DownTo(ctx, steps, version)
+}
+
+// DownSteps rolls back the n most recently applied migrations.
+func (m *Migrator) DownSteps(ctx context.Context, n int) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.DownSteps(ctx, steps, n)
+}
+
+// Redo rolls back and then reapplies the last migration.
+func (m *Migrator) Redo(ctx context.Context) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Redo(ctx, steps)
+}
+
+// Status returns the status of every known or recorded migration.
+func (m *Migrator) Status(ctx context.Context) ([]StatusRow, error) {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Status(ctx, steps)
+}
+
+// Version returns the newest applied migration version, 0 if none.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return r.DBVersion(ctx)
+}
+
+// History returns entries of the history table matching f, newest first.
+func (m *Migrator) History(ctx context.Context, f HistoryFilter) ([]HistoryEntry, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.History(ctx, f)
+}
+
+// SchemaSnapshot returns a normalised schema snapshot of the database built from pg_catalog.
+func (m *Migrator) SchemaSnapshot(ctx context.Context) (string, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return "", err
+	}
+	return r.SchemaSnapshot(ctx)
+}
+
+// CheckSchema compares the schema file at path with a fresh snapshot of the database.
+func (m *Migrator) CheckSchema(ctx context.Context, path string) (SchemaDrift, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	return r.CheckSchema(ctx, path)
+}
+
+// Diff compares the live schema with the schema the migrations produce in a
+// throwaway database on the same server.
+func (m *Migrator) Diff(ctx context.Context) (SchemaDrift, error) {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return SchemaDrift{}, err
+	}
+	return r.Diff(ctx, steps)
+}
+
+// PlanUp returns the migrations UpTo would apply, without executing them.
+func (m *Migrator) PlanUp(ctx context.Context, version int64) (Plan, error) {
+	r, steps, err := m.planner(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	return r.PlanUp(ctx, steps, version)
+}
+
+// PlanDown returns the migrations a rollback would revert, without executing them.
+// Use version -1 to limit by n only and n <= 0 to limit by version only.
+func (m *Migrator) PlanDown(ctx context.Context, version int64, n int) (Plan, error) {
+	r, steps, err := m.planner(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	return r.PlanDown(ctx, steps, version, n)
+}
+
+// Verify compares checksums of applied migrations with the migration sources.
+func (m *Migrator) Verify(ctx context.Context) ([]Drift, error) {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Verify(ctx, steps)
+}
+
+// AcceptChecksums stores the current checksums for drifted migrations and returns them.
+func (m *Migrator) AcceptChecksums(ctx context.Context) ([]Drift, error) {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.AcceptChecksums(ctx, steps)
+}
+
+// Repair removes schema table rows stuck in 'failed' or 'applying' state, so
+// the next Up retries those migrations. It returns the removed rows.
+func (m *Migrator) Repair(ctx context.Context) ([]Record, error) {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repair(ctx)
+}
+
+// Force records every migration up to and including version as applied and
+// removes records of newer ones, without executing any migration code.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Force(ctx, steps, version)
+}
+
+// MarkApplied records the migration as applied without executing it.
+func (m *Migrator) MarkApplied(ctx context.Context, version int64) error {
+	r, steps, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return r.MarkApplied(ctx, steps, version)
+}
+
+// MarkRolledBack removes the record of the migration without executing its Down section.
+func (m *Migrator) MarkRolledBack(ctx context.Context, version int64) error {
+	r, err := m.ensure(ctx)
+	if err != nil {
+		return err
+	}
+	return r.MarkRolledBack(ctx, version)
+}
+
+// Validate checks the migration sources and the registered Go migrations without
+// touching the database. Go migrations must have a <version>_<name>.go file only
+// when the migrations come from WithDir.
+func (m *Migrator) Validate() ([]Problem, error) {
+	fsys := m.cfg.FS
+	if m.dir != "" {
+		// ошибка с путём каталога понятнее, чем "open ." от os.DirFS
+		if _, err := os.Stat(m.dir); err != nil {
+			return nil, err
+		}
+		fsys = os.DirFS(m.dir)
+	}
+	return im.Validate(fsys, goReg.Steps(), im.ValidateOptions{RequireDown: m.cfg.RequireDown, GoFiles: m.dir != ""})
+}
+
+// Lint checks the migrations for operations that lock or rewrite big tables
+// without touching the database. WithLintSeverity overrides rule severities.
+func (m *Migrator) Lint() ([]LintFinding, error) {
+	steps, err := m.source()
+	if err != nil {
+		return nil, err
+	}
+	return im.Lint(steps, lintSeverity(m.cfg))
+}
@@ -0,0 +1,99 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNew_Options(t *testing.T) {
+	if _, err := New(WithFS(fstest.MapFS{})); err == nil {
+		t.Error("expected error without a database")
+	}
+	if _, err := New(WithDSN("sqlite://a.db"), WithDSN("sqlite://b.db")); err == nil {
+		t.Error("expected error for two databases")
+	}
+	if _, err := New(WithSQLDB(&sql.DB{}, "oracle")); err == nil {
+		t.Error("expected error for unsupported dialect")
+	}
+	if _, err := New(WithDSN("sqlite://a.db"), WithFS(fstest.MapFS{}), WithDir("migrations")); err == nil {
+		t.Error("expected error for two sources")
+	}
+	m, err := New(WithDSN("invalid-dsn"))
+	if err != nil {
+		t.Fatalf("New must not connect: %v", err)
+	}
+	if err := m.Up(context.Background()); err == nil {
+		t.Error("expected connection error on first use")
+	}
+}
+
+func TestMigrator_SQLDB(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	fsys := fstest.MapFS{
+		"1_init.sql": {Data: []byte("-- +migrate Up\nCREATE TABLE foo(id INTEGER);\n-- +migrate Down\nDROP TABLE foo;\n")},
+		"2_seed.sql": {Data: []byte("-- +migrate Up\nINSERT INTO foo VALUES (1);\n-- +migrate Down\nDELETE FROM foo;\n")},
+	}
+	m, err := New(WithSQLDB(db, DialectSQLite), WithFS(fsys), WithSchemaTable("app_migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.PlanUp(ctx, NoTarget)
+	if err != nil || len(plan.Steps) != 2 {
+		t.Fatalf("unexpected plan %+v (%v)", plan, err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if v, err := m.Version(ctx); err != nil || v != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", v, err)
+	}
+	if drift, err := m.Verify(ctx); err != nil || len(drift) != 0 {
+		t.Fatalf("unexpected drift %+v (%v)", drift, err)
+	}
+	if problems, err := m.Validate(); err != nil || len(problems) != 0 {
+		t.Fatalf("unexpected problems %+v (%v)", problems, err)
+	}
+	if _, err := m.Lint(); err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+	if err := m.MarkRolledBack(ctx, 2); err != nil {
+		t.Fatalf("mark-rolled-back: %v", err)
+	}
+	if err := m.MarkApplied(ctx, 2); err != nil {
+		t.Fatalf("mark-applied: %v", err)
+	}
+	if err := m.Force(ctx, 2); err != nil {
+		t.Fatalf("force: %v", err)
+	}
+	if removed, err := m.Repair(ctx); err != nil || len(removed) != 0 {
+		t.Fatalf("unexpected repair %+v (%v)", removed, err)
+	}
+	if err := m.Down(ctx); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	rows, err := m.Status(ctx)
+	if err != nil || len(rows) != 2 || rows[1].Status != "pending" {
+		t.Fatalf("unexpected status %+v (%v)", rows, err)
+	}
+	m.Close()
+	// пул принадлежит вызывающему и остаётся открытым
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM app_migrations").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected 1 record in caller's db, got %d (%v)", n, err)
+	}
+	// файл блокировки создается рядом с базой и удаляется после каждого запуска
+	for _, pattern := range []string{filepath.Join(dir, "*.lock"), "*.lock"} {
+		if left, _ := filepath.Glob(pattern); len(left) > 0 {
+			t.Fatalf("lock files left behind: %v", left)
+		}
+	}
+}
@@ -7,8 +7,37 @@ import (
 
 var goReg = im.NewRegistry()
 
-// Register регистрирует Go‑миграцию с идентификатором <timestamp>_<name>
+// GoTxFunc is a Go migration run inside the migration transaction. The context
+// carries the caller's cancellation and deadlines.
+type GoTxFunc = im.GoTxFunc
+
+// GoPoolFunc is a Go migration run outside a transaction on the connection pool.
+type GoPoolFunc = im.GoPoolFunc
+
+// Register регистрирует Go‑миграцию с идентификатором <timestamp>_<name>.
 // Используется в приложениях, которые подключают библиотеку напрямую.
-func Register(version int64, name string, up func(pgx.Tx) error, down func(pgx.Tx) error) error {
-	return goReg.Register(version, name, up, down)
+// Функции не получают контекст; в новом коде используйте RegisterContext.
+// checksum обязательна и должна меняться при каждой правке логики миграции:
+// используйте SourceChecksum от встроенного исходного текста файла.
+func Register(version int64, name, checksum string, up func(pgx.Tx) error, down func(pgx.Tx) error) error {
+	return goReg.Register(version, name, checksum, up, down)
+}
+
+// RegisterContext регистрирует транзакционную Go‑миграцию, получающую контекст запуска:
+// отмена по SIGINT и таймауты доходят до долгих миграций данных.
+// checksum обязательна: используйте SourceChecksum от встроенного исходного текста файла.
+func RegisterContext(version int64, name, checksum string, up, down GoTxFunc) error {
+	return goReg.RegisterContext(version, name, checksum, up, down)
 }
+
+// RegisterNoTx регистрирует Go‑миграцию, выполняемую вне транзакции на пуле соединений,
+// например пакетное заполнение данных с фиксацией по частям. Только для PostgreSQL.
+// checksum обязательна, как и в RegisterContext.
+func RegisterNoTx(version int64, name, checksum string, up, down GoPoolFunc) error {
+	return goReg.RegisterNoTx(version, name, checksum, up, down)
+}
+
+// SourceChecksum returns the checksum of a Go migration's source text. Embed the
+// migration file with go:embed and pass its contents, so the checksum changes
+// whenever the file is edited and verify reports the drift.
+func SourceChecksum(src []byte) string { return im.SourceChecksum(src) }